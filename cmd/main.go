@@ -3,6 +3,8 @@ package main
 import (
 	"flag"
 	"fmt"
+	"strings"
+	"time"
 	"urlShortener/pkg/database"
 	"urlShortener/pkg/webserver"
 )
@@ -11,11 +13,100 @@ func main() {
 	username := flag.String("username", "neo4j", "username for neo4j instance")
 	password := flag.String("password", "neo4j", "password for neo4j instance")
 	secret := flag.String("secret", "potato", "secret for jwt signing")
+	storeType := flag.String("store", "neo4j", "storage backend to use: neo4j, memory or bolt")
+	dataFile := flag.String("file", "urlShortener.db", "data file for the bolt store")
+	strategy := flag.String("codes", webserver.StrategyRandom, "short code strategy: random, counter, hashids or hash")
+	alphabet := flag.String("alphabet", webserver.DefaultAlphabet, "characters used in generated short codes")
+	codeLength := flag.Int("codeLength", 8, "length of generated short codes")
+	maxCodeLength := flag.Int("maxCodeLength", 12, "length generated short codes may grow to on collision")
+	salt := flag.String("salt", "", "salt for hashids short codes")
+	customAlphabet := flag.String("customAlphabet", webserver.DefaultCustomAlphabet, "characters allowed in requested short codes")
+	customMinLength := flag.Int("customMinLength", 3, "minimum length of requested short codes")
+	customMaxLength := flag.Int("customMaxLength", 32, "maximum length of requested short codes")
+	blocklistFile := flag.String("blocklist", "", "file of words not allowed in short codes, one per line")
+	schemes := flag.String("schemes", strings.Join(webserver.DefaultAllowedSchemes, ","), "comma separated url schemes links may point at")
+	allowPrivate := flag.Bool("allowPrivate", false, "allow links to private and loopback addresses")
+	denylistFile := flag.String("denylist", "", "file of domains links may not point at, one per line")
+	dedupe := flag.Bool("dedupe", false, "reuse a user's existing short code when they shorten the same url again")
+	sweepInterval := flag.Duration("sweep", time.Hour, "how often to clear out expired links, 0 to disable")
+	archiveExpired := flag.Bool("archive", false, "archive expired links instead of deleting them")
+	trashRetention := flag.Duration("trashRetention", 30*24*time.Hour, "how long deleted links stay in the trash, 0 to keep them until purged by hand")
+	deletionGrace := flag.Duration("deletionGrace", 7*24*time.Hour, "how long deleted accounts can be recovered by logging in, 0 to delete them straight away")
+	clickBuffer := flag.Int("clickBuffer", 10000, "clicks to buffer before dropping them, 0 to record synchronously")
+	clickWorkers := flag.Int("clickWorkers", 2, "workers writing buffered clicks to the store")
+	clickBatch := flag.Int("clickBatch", 100, "clicks written to the store per batch")
+	clickFlush := flag.Duration("clickFlush", time.Second, "longest a buffered click waits before being written")
+	geoIPDatabase := flag.String("geoip", "", "MaxMind-format .mmdb file used to locate clicks")
+	redirectStatus := flag.Int("redirect", webserver.DefaultRedirectStatus, "status code for links without their own redirect type: 301, 302, 307 or 308")
 	flag.Parse()
-	err := database.Init(*username, *password)
+	store, err := openStore(*storeType, *username, *password, *dataFile)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	webserver.Run(*secret)
+	defer store.Close()
+	var blocklist []string
+	if *blocklistFile != "" {
+		blocklist, err = webserver.LoadWordList(*blocklistFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	var denylist []string
+	if *denylistFile != "" {
+		denylist, err = webserver.LoadWordList(*denylistFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	config := webserver.Config{
+		Secret: *secret,
+		ShortCodes: webserver.ShortCodeConfig{
+			Strategy:  *strategy,
+			Alphabet:  *alphabet,
+			Length:    *codeLength,
+			MaxLength: *maxCodeLength,
+			Salt:      *salt,
+		},
+		CustomCodes: webserver.CustomCodeConfig{
+			Alphabet:  *customAlphabet,
+			MinLength: *customMinLength,
+			MaxLength: *customMaxLength,
+			Blocklist: blocklist,
+		},
+		Destinations: webserver.DestinationConfig{
+			AllowedSchemes: strings.Split(*schemes, ","),
+			AllowPrivate:   *allowPrivate,
+			DenyDomains:    denylist,
+		},
+		Clicks: webserver.ClickConfig{
+			BufferSize:    *clickBuffer,
+			Workers:       *clickWorkers,
+			BatchSize:     *clickBatch,
+			FlushInterval: *clickFlush,
+		},
+		GeoIPDatabase:  *geoIPDatabase,
+		Dedupe:         *dedupe,
+		SweepInterval:  *sweepInterval,
+		ArchiveExpired: *archiveExpired,
+		TrashRetention: *trashRetention,
+		DeletionGrace:  *deletionGrace,
+		RedirectStatus: *redirectStatus,
+	}
+	webserver.Run(config, store)
+}
+
+func openStore(storeType, username, password, dataFile string) (database.Store, error) {
+	switch storeType {
+	case "neo4j":
+		return database.NewNeo4jStore(username, password)
+	case "memory":
+		return database.NewMemoryStore(), nil
+	case "bolt":
+		return database.NewBoltStore(dataFile)
+	default:
+		return nil, fmt.Errorf("unknown store %q", storeType)
+	}
 }
@@ -1,10 +1,12 @@
 module urlShortener
 
-go 1.14
+go 1.16
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/mux v1.7.4
 	github.com/neo4j/neo4j-go-driver v1.8.0
+	github.com/oschwald/geoip2-golang v1.4.0
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 )
@@ -0,0 +1,718 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+var (
+	urlsBucket      = []byte("urls")
+	usersBucket     = []byte("users")
+	ownersBucket    = []byte("owners")
+	archivedBucket  = []byte("archived")
+	clicksBucket    = []byte("clicks")
+	campaignsBucket = []byte("campaigns")
+	versionsBucket  = []byte("versions")
+	trashBucket     = []byte("trash")
+)
+
+// BoltStore is a Store kept in a single bbolt data file. URLs and users are
+// stored as JSON keyed by short code and username, and MADE edges live in
+// their own bucket mapping short code to owning username. Clicks are kept in
+// a sub-bucket per short code inside the clicks bucket, and campaigns in a
+// sub-bucket per username inside the campaigns bucket. Versions likewise get
+// a sub-bucket per short code, keyed by version number. Trashed URLs move
+// from the urls bucket to the trash bucket, keeping their owner and clicks.
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, usersBucket, ownersBucket, archivedBucket, clicksBucket, campaignsBucket, versionsBucket, trashBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) AddURL(record Record) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		if urls.Get([]byte(record.Short)) != nil || tx.Bucket(trashBucket).Get([]byte(record.Short)) != nil {
+			return ErrURLTaken
+		}
+		record.Created = time.Now()
+		record.Clicks = 0
+		return putJSON(urls, record.Short, record)
+	})
+}
+
+func (s *BoltStore) GetUrl(short string) (Record, error) {
+	var record Record
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		found, err := getJSON(tx.Bucket(urlsBucket), short, &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("url not found")
+		}
+		return nil
+	})
+	return record, err
+}
+
+func (s *BoltStore) UpdateURL(record Record) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		var existing Record
+		found, err := getJSON(urls, record.Short, &existing)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("url not found")
+		}
+		record.Created = existing.Created
+		record.Clicks = existing.Clicks
+
+		versions, err := tx.Bucket(versionsBucket).CreateBucketIfNotExists([]byte(record.Short))
+		if err != nil {
+			return err
+		}
+		number, err := versions.NextSequence()
+		if err != nil {
+			return err
+		}
+		version := Version{Number: int(number), Record: existing, ReplacedAt: time.Now()}
+		err = putJSON(versions, fmt.Sprintf("%020d", number), version)
+		if err != nil {
+			return err
+		}
+		return putJSON(urls, record.Short, record)
+	})
+}
+
+func (s *BoltStore) GetVersions(short string) ([]Version, error) {
+	var versions []Version
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		versions, err = versionsTx(tx, []byte(short))
+		return err
+	})
+	return versions, err
+}
+
+func (s *BoltStore) DeleteURL(short string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return deleteURLTx(tx, []byte(short))
+	})
+}
+
+func (s *BoltStore) TrashURL(short string, now time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		var record Record
+		found, err := getJSON(urls, short, &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("url not found")
+		}
+		record.DeletedAt = now
+		err = putJSON(tx.Bucket(trashBucket), short, record)
+		if err != nil {
+			return err
+		}
+		return urls.Delete([]byte(short))
+	})
+}
+
+func (s *BoltStore) RestoreURL(short string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		trash := tx.Bucket(trashBucket)
+		var record Record
+		found, err := getJSON(trash, short, &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("url not found")
+		}
+		record.DeletedAt = time.Time{}
+		err = putJSON(tx.Bucket(urlsBucket), short, record)
+		if err != nil {
+			return err
+		}
+		return trash.Delete([]byte(short))
+	})
+}
+
+func (s *BoltStore) GetTrashOf(username string) ([]Record, error) {
+	var records []Record
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		owners := tx.Bucket(ownersBucket)
+		return tx.Bucket(trashBucket).ForEach(func(k, v []byte) error {
+			if string(owners.Get(k)) != username {
+				return nil
+			}
+			var record Record
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].DeletedAt.After(records[j].DeletedAt)
+	})
+	return records, nil
+}
+
+func (s *BoltStore) PurgeTrash(before time.Time) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var purge [][]byte
+		err := tx.Bucket(trashBucket).ForEach(func(k, v []byte) error {
+			var record Record
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+			if record.DeletedAt.Before(before) {
+				purge = append(purge, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, short := range purge {
+			err = deleteURLTx(tx, short)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (s *BoltStore) RecordClicks(clicks []Click) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		for _, click := range clicks {
+			var record Record
+			found, err := getJSON(urls, click.Short, &record)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			record.Clicks++
+			err = putJSON(urls, click.Short, record)
+			if err != nil {
+				return err
+			}
+
+			bucket, err := tx.Bucket(clicksBucket).CreateBucketIfNotExists([]byte(click.Short))
+			if err != nil {
+				return err
+			}
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = putJSON(bucket, fmt.Sprintf("%020d", id), click)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetClicks(short string, from, to time.Time) ([]Click, error) {
+	var clicks []Click
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		clicks, err = clicksTx(tx, []byte(short))
+		return err
+	})
+	return clicksBetween(clicks, from, to), err
+}
+
+func (s *BoltStore) ClickStatsOf(username string) (map[string]ClickStats, error) {
+	stats := make(map[string]ClickStats)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		return tx.Bucket(ownersBucket).ForEach(func(k, v []byte) error {
+			if string(v) != username || urls.Get(k) == nil {
+				return nil
+			}
+			clicks, err := clicksTx(tx, k)
+			if err != nil {
+				return err
+			}
+			stats[string(k)] = summariseClicks(clicks)
+			return nil
+		})
+	})
+	return stats, err
+}
+
+func (s *BoltStore) SweepExpired(now time.Time, archive bool) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		owners := tx.Bucket(ownersBucket)
+		archived := tx.Bucket(archivedBucket)
+
+		var expired []Record
+		err := urls.ForEach(func(k, v []byte) error {
+			var record Record
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+			if record.Expired(now) {
+				expired = append(expired, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, record := range expired {
+			if archive {
+				id, err := archived.NextSequence()
+				if err != nil {
+					return err
+				}
+				owner := owners.Get([]byte(record.Short))
+				clicks, err := clicksTx(tx, []byte(record.Short))
+				if err != nil {
+					return err
+				}
+				versions, err := versionsTx(tx, []byte(record.Short))
+				if err != nil {
+					return err
+				}
+				err = putJSON(archived, fmt.Sprintf("%020d", id), archivedRecord{
+					Record:   record,
+					Owner:    string(owner),
+					Clicks:   clicks,
+					Versions: versions,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			err = deleteURLTx(tx, []byte(record.Short))
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (s *BoltStore) AddUser(username, password string) error {
+	hashedPass, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		if users.Get([]byte(username)) != nil {
+			return ErrUserTaken
+		}
+		return putJSON(users, username, User{
+			Username: username,
+			Password: hashedPass,
+			Created:  time.Now(),
+		})
+	})
+}
+
+func (s *BoltStore) GetUser(username string) (User, error) {
+	var user User
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		found, err := getJSON(tx.Bucket(usersBucket), username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("user not found")
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (s *BoltStore) DeleteUser(username string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		owners := tx.Bucket(ownersBucket)
+
+		var owned [][]byte
+		err := owners.ForEach(func(k, v []byte) error {
+			if string(v) == username {
+				owned = append(owned, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, short := range owned {
+			err = deleteURLTx(tx, short)
+			if err != nil {
+				return err
+			}
+		}
+
+		archived := tx.Bucket(archivedBucket)
+		var archivedKeys [][]byte
+		err = archived.ForEach(func(k, v []byte) error {
+			var record archivedRecord
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+			if record.Owner == username {
+				archivedKeys = append(archivedKeys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range archivedKeys {
+			err = archived.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		campaigns := tx.Bucket(campaignsBucket)
+		if campaigns.Bucket([]byte(username)) != nil {
+			err = campaigns.DeleteBucket([]byte(username))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(usersBucket).Delete([]byte(username))
+	})
+}
+
+func (s *BoltStore) ScheduleUserDeletion(username string, at time.Time, transferTo string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		var user User
+		found, err := getJSON(users, username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("user not found")
+		}
+		user.DeleteAt = at
+		user.TransferTo = transferTo
+		return putJSON(users, username, user)
+	})
+}
+
+func (s *BoltStore) CancelUserDeletion(username string) error {
+	return s.ScheduleUserDeletion(username, time.Time{}, "")
+}
+
+func (s *BoltStore) GetUsersDueForDeletion(before time.Time) ([]User, error) {
+	var users []User
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var user User
+			err := json.Unmarshal(v, &user)
+			if err != nil {
+				return err
+			}
+			if !user.DeleteAt.IsZero() && user.DeleteAt.Before(before) {
+				users = append(users, user)
+			}
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *BoltStore) TransferURLs(from, to string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(to)) == nil {
+			return fmt.Errorf("user not found")
+		}
+
+		owners := tx.Bucket(ownersBucket)
+		var owned [][]byte
+		err := owners.ForEach(func(k, v []byte) error {
+			if string(v) == from {
+				owned = append(owned, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, short := range owned {
+			err = owners.Put(short, []byte(to))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Link(username, shortened string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(username)) == nil {
+			return nil
+		}
+		if tx.Bucket(urlsBucket).Get([]byte(shortened)) == nil {
+			return nil
+		}
+		return tx.Bucket(ownersBucket).Put([]byte(shortened), []byte(username))
+	})
+}
+
+func (s *BoltStore) GetURLsOf(username string) ([]Record, error) {
+	var records []Record
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		return tx.Bucket(ownersBucket).ForEach(func(k, v []byte) error {
+			if string(v) != username {
+				return nil
+			}
+			var record Record
+			found, err := getJSON(urls, string(k), &record)
+			if err != nil || !found {
+				return nil
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	return records, nil
+}
+
+func (s *BoltStore) FindURLOf(username, normalised string) (Record, error) {
+	records, err := s.GetURLsOf(username)
+	if err != nil {
+		return Record{}, err
+	}
+	for _, record := range records {
+		if record.Normalised == normalised {
+			return record, nil
+		}
+	}
+	return Record{}, fmt.Errorf("url not found")
+}
+
+func (s *BoltStore) VerifyOwns(username, short string) bool {
+	owns := false
+	s.db.View(func(tx *bbolt.Tx) error {
+		owner := tx.Bucket(ownersBucket).Get([]byte(short))
+		owns = owner != nil && string(owner) == username
+		return nil
+	})
+	return owns
+}
+
+func (s *BoltStore) OwnerOf(short string) (string, error) {
+	var owner string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(urlsBucket).Get([]byte(short)) == nil {
+			return fmt.Errorf("url not found")
+		}
+		owner = string(tx.Bucket(ownersBucket).Get([]byte(short)))
+		return nil
+	})
+	return owner, err
+}
+
+func (s *BoltStore) SaveCampaign(username string, campaign Campaign) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(username)) == nil {
+			return fmt.Errorf("user not found")
+		}
+		bucket, err := tx.Bucket(campaignsBucket).CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+		return putJSON(bucket, campaign.Name, campaign)
+	})
+}
+
+func (s *BoltStore) GetCampaigns(username string) ([]Campaign, error) {
+	var campaigns []Campaign
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(campaignsBucket).Bucket([]byte(username))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var campaign Campaign
+			err := json.Unmarshal(v, &campaign)
+			if err != nil {
+				return err
+			}
+			campaigns = append(campaigns, campaign)
+			return nil
+		})
+	})
+	return campaigns, err
+}
+
+func (s *BoltStore) DeleteCampaign(username, name string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(campaignsBucket).Bucket([]byte(username))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+func (s *BoltStore) NextID() (uint64, error) {
+	var id uint64
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		id, err = tx.Bucket(urlsBucket).NextSequence()
+		return err
+	})
+	return id, err
+}
+
+// deleteURLTx removes a record along with its owner and clicks.
+func deleteURLTx(tx *bbolt.Tx, short []byte) error {
+	err := tx.Bucket(urlsBucket).Delete(short)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(trashBucket).Delete(short)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(ownersBucket).Delete(short)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(clicksBucket).DeleteBucket(short)
+	if err != nil && err != bbolt.ErrBucketNotFound {
+		return err
+	}
+	err = tx.Bucket(versionsBucket).DeleteBucket(short)
+	if err == bbolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
+
+func clicksTx(tx *bbolt.Tx, short []byte) ([]Click, error) {
+	bucket := tx.Bucket(clicksBucket).Bucket(short)
+	if bucket == nil {
+		return nil, nil
+	}
+
+	var clicks []Click
+	err := bucket.ForEach(func(k, v []byte) error {
+		var click Click
+		err := json.Unmarshal(v, &click)
+		if err != nil {
+			return err
+		}
+		clicks = append(clicks, click)
+		return nil
+	})
+	return clicks, err
+}
+
+// versionsTx returns a record's versions newest first.
+func versionsTx(tx *bbolt.Tx, short []byte) ([]Version, error) {
+	bucket := tx.Bucket(versionsBucket).Bucket(short)
+	if bucket == nil {
+		return nil, nil
+	}
+
+	var versions []Version
+	c := bucket.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		var version Version
+		err := json.Unmarshal(v, &version)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func putJSON(bucket *bbolt.Bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+func getJSON(bucket *bbolt.Bucket, key string, value interface{}) (bool, error) {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
@@ -1,300 +1,288 @@
 package database
 
 import (
-	"fmt"
-	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"errors"
 	"golang.org/x/crypto/bcrypt"
 	"time"
 )
 
+// Record is a shortened URL.
 type Record struct {
-	Short   string
-	Long    string
-	Created time.Time
-}
-
-type User struct {
-	Username string
+	Short string
+	Long  string
+	// Normalised is Long in a canonical form, used to spot the same
+	// destination typed differently. It is empty unless dedupe is on.
+	Normalised string
+	Created    time.Time
+	// ExpiresAt is zero for links that never expire.
+	ExpiresAt time.Time
+	// MaxClicks is zero for links with unlimited clicks.
+	MaxClicks int64
+	Clicks    int64
+	Rules     []TargetRule
+	// Variants split visitors who match none of the Rules by weight. Without
+	// any, they are sent to Long.
+	Variants []Variant
+	// Password is the bcrypt hash of the password visitors must enter before
+	// being redirected, and is empty for links anyone may follow.
 	Password string
-	Created  time.Time
+	// Interstitial shows every visitor a preview of the link before they
+	// follow it.
+	Interstitial bool
+	// RedirectStatus is the HTTP status visitors are redirected with, with
+	// zero leaving it to the server.
+	RedirectStatus int
+	// Passthrough forwards any path after the short code and the query string
+	// on to the destination.
+	Passthrough bool
+	// UTM is added to the destination's query when the link is followed, so
+	// Long stays as entered.
+	UTM UTM
+	// EditedAt and EditedBy record the last change to the link's destination
+	// or settings, and are zero for links unchanged since they were created.
+	EditedAt time.Time
+	EditedBy string
+	// DeletedAt is when the record was moved to the trash, and is zero for
+	// live records.
+	DeletedAt time.Time
 }
 
-const (
-	serverURL    = "bolt://localhost:7687"
-	databaseName = "neo4j"
-	bcryptCost   = 10
-)
-
-var driver neo4j.Driver
+// Version is an earlier state of a record, kept whenever UpdateURL replaces
+// it. Versions of a record are numbered from 1 in the order they were kept.
+type Version struct {
+	Number     int
+	Record     Record
+	ReplacedAt time.Time
+}
 
-func Init(username, password string) error {
-	d, err := neo4j.NewDriver(serverURL, neo4j.BasicAuth(username, password, ""), func(config *neo4j.Config) {
-		config.Encrypted = false
-	})
-	if err != nil {
-		return err
-	}
-	driver = d
-	return nil
+// TargetRule sends visitors matching Kind and Value to Destination instead of
+// the record's Long. Rules are tried in order and the first match wins.
+type TargetRule struct {
+	Kind        string
+	Value       string
+	Destination string
 }
 
-func AddURL(long, short string) error {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeWrite,
-		DatabaseName: databaseName,
+// SetPassword protects the record with password, or removes the protection
+// when password is empty.
+func (r *Record) SetPassword(password string) error {
+	if password == "" {
+		r.Password = ""
+		return nil
 	}
-	session, err := driver.NewSession(sessionConfig)
+	hashedPass, err := hashPassword(password)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
-
-	data := map[string]interface{}{"long": long, "short": short}
-	res, err := session.Run("CREATE (u:URL {long:$long, short:$short, created: datetime({ timezone: 'Europe/London' })})", data)
-	if err != nil {
-		return err
-	}
-
-	return res.Err()
+	r.Password = hashedPass
+	return nil
 }
 
-func GetUrl(short string) (Record, error) {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeRead,
-		DatabaseName: databaseName,
+// CheckPassword reports whether password unlocks the record.
+func (r Record) CheckPassword(password string) bool {
+	if r.Password == "" {
+		return true
 	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return Record{}, err
-	}
-	defer session.Close()
-
-	data := map[string]interface{}{"short": short}
-	res, err := session.Run("MATCH (u:URL {short:$short}) RETURN u LIMIT 1", data)
-	if err != nil {
-		return Record{}, err
-	}
-
-	for res.Next() {
-		node := res.Record().GetByIndex(0).(neo4j.Node)
-		record, err := ParseRecord(node)
-		if err != nil {
-			continue
-		}
-		return record, nil
-	}
-
-	return Record{}, fmt.Errorf("url not found")
+	err := bcrypt.CompareHashAndPassword([]byte(r.Password), []byte(password))
+	return err == nil
 }
 
-func GetUser(username string) (User, error) {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeRead,
-		DatabaseName: databaseName,
-	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return User{}, err
-	}
-	defer session.Close()
-
-	data := map[string]interface{}{"username": username}
-	res, err := session.Run("MATCH (u:USER {username:$username}) RETURN u LIMIT 1", data)
-	if err != nil {
-		return User{}, err
-	}
-
-	for res.Next() {
-		r := res.Record().GetByIndex(0).(neo4j.Node)
-		user, err := ParseUser(r)
-		if err != nil {
-			continue
-		}
-		return user, nil
-	}
-
-	return User{}, fmt.Errorf("user not found")
+// UTM holds the campaign parameters appended to a destination as utm_source,
+// utm_medium and utm_campaign. Empty fields are left off.
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
 }
 
-func DeleteUser(username string) error {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeWrite,
-		DatabaseName: databaseName,
-	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	data := map[string]interface{}{"username": username}
-	_, err = session.Run("MATCH (user:USER {username:$username})-[r:MADE]->(url:URL) DETACH DELETE user DETACH DELETE url", data)
-	return err
+func (u UTM) IsZero() bool {
+	return u == UTM{}
 }
 
-func VerifyUser(username, password string) bool {
-	user, err := GetUser(username)
-	if err != nil {
-		return false
-	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	return err == nil
+// Campaign is a named set of UTM parameters a user keeps to reuse when
+// creating links.
+type Campaign struct {
+	Name string
+	UTM  UTM
 }
 
-func AddUser(username, password string) error {
-	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
-	if err != nil {
-		return err
-	}
-
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeWrite,
-		DatabaseName: databaseName,
-	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	data := map[string]interface{}{"username": username, "password": string(hashedPass)}
-	res, err := session.Run("CREATE (u:USER {username:$username, password:$password, created:datetime({ timezone: 'Europe/London' })})", data)
-	if err != nil {
-		return err
-	}
-
-	return res.Err()
+// Variant is one arm of an A/B split. Each visitor is assigned a variant with
+// probability proportional to its Weight, and Name is recorded on their
+// clicks so the variants can be compared.
+type Variant struct {
+	Name        string
+	Destination string
+	Weight      int
 }
 
-func Link(username, shortened string) error {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeWrite,
-		DatabaseName: databaseName,
-	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return err
+// Expired reports whether the record's expiry date has passed or its clicks
+// have been used up.
+func (r Record) Expired(now time.Time) bool {
+	if !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt) {
+		return true
 	}
-	defer session.Close()
+	return r.MaxClicks > 0 && r.Clicks >= r.MaxClicks
+}
 
-	data := map[string]interface{}{"username": username, "short": shortened}
-	res, err := session.Run("MATCH (u:USER {username:$username}) MATCH (url:URL {short:$short}) CREATE (u)-[r:MADE]->(url)", data)
-	if err != nil {
-		return err
-	}
+// Click is a single follow of a short link. IPHash is a keyed hash of the
+// visitor's address, so unique visitors can be counted without keeping IPs.
+// Country is an ISO country code, and it and City are empty when unknown.
+// Variant names the A/B variant served, if the link was split.
+type Click struct {
+	Short     string
+	Time      time.Time
+	Referer   string
+	UserAgent string
+	IPHash    string
+	Country   string
+	City      string
+	Variant   string
+}
 
-	return res.Err()
+// ClickStats summarises the clicks on one link.
+type ClickStats struct {
+	Total  int64
+	Unique int64
+	Last   time.Time
 }
 
-func GetURLsOf(username string) ([]Record, error) {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeRead,
-		DatabaseName: databaseName,
-	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return nil, err
-	}
-	defer session.Close()
+// archivedRecord is an expired record swept aside by the memory and bolt
+// stores, along with whoever made it, its clicks and its versions.
+type archivedRecord struct {
+	Record   Record
+	Owner    string
+	Clicks   []Click
+	Versions []Version
+}
 
-	data := map[string]interface{}{"username": username}
-	res, err := session.Run("MATCH (u:URL)--(USER {username:$username}) RETURN u", data)
-	if err != nil {
-		return nil, err
-	}
+// User is an account.
+type User struct {
+	Username string
+	Password string
+	Created  time.Time
+	// DeleteAt is when an account the user asked to delete will be deleted,
+	// and is zero otherwise.
+	DeleteAt time.Time
+	// TransferTo names the user who will be given their links at that point
+	// instead of them being deleted.
+	TransferTo string
+}
 
-	var records []Record
+const bcryptCost = 10
 
-	for res.Next() {
-		r := res.Record().GetByIndex(0).(neo4j.Node)
-		record, err := ParseRecord(r)
-		if err != nil {
-			continue
-		}
-		records = append(records, record)
-	}
+var (
+	ErrURLTaken  = errors.New("short url already taken")
+	ErrUserTaken = errors.New("username already taken")
+)
 
-	return records, nil
+// Store is the set of operations the webserver needs from a storage backend.
+type Store interface {
+	// AddURL sets Created itself and starts Clicks at zero. It returns
+	// ErrURLTaken rather than store a second record with the same Short.
+	AddURL(record Record) error
+	GetUrl(short string) (Record, error)
+	// UpdateURL replaces the settings of an existing record, keeping its
+	// Short, Created and Clicks, and keeps the record it replaces as a new
+	// version.
+	UpdateURL(record Record) error
+	// GetVersions returns a record's versions newest first.
+	GetVersions(short string) ([]Version, error)
+	// DeleteURL deletes a record for good, along with its clicks and
+	// versions, whether or not it is in the trash.
+	DeleteURL(short string) error
+	// TrashURL moves a record into the trash, where it keeps its short code,
+	// owner, clicks and versions but is otherwise treated as deleted.
+	TrashURL(short string, now time.Time) error
+	RestoreURL(short string) error
+	// GetTrashOf lists a user's trashed records, most recently trashed first.
+	GetTrashOf(username string) ([]Record, error)
+	// PurgeTrash deletes everything trashed before the given time for good.
+	PurgeTrash(before time.Time) (int, error)
+	// RecordClicks increments Clicks for every click it keeps. Clicks on
+	// records that no longer exist are dropped.
+	RecordClicks(clicks []Click) error
+	// GetClicks returns the clicks made in [from, to) in time order.
+	GetClicks(short string, from, to time.Time) ([]Click, error)
+	// ClickStatsOf summarises the clicks on each of a user's live links,
+	// leaving out those in the trash.
+	ClickStatsOf(username string) (map[string]ClickStats, error)
+	// SweepExpired removes every expired record, keeping a copy out of the
+	// way of new records, with its owner, clicks and versions, when archive
+	// is set.
+	SweepExpired(now time.Time, archive bool) (int, error)
+	// AddUser returns ErrUserTaken rather than store a second user with the
+	// same username.
+	AddUser(username, password string) error
+	GetUser(username string) (User, error)
+	// DeleteUser deletes a user along with their links, live, trashed or
+	// archived, and their campaigns.
+	DeleteUser(username string) error
+	// ScheduleUserDeletion sets a user's DeleteAt and TransferTo.
+	ScheduleUserDeletion(username string, at time.Time, transferTo string) error
+	// CancelUserDeletion clears a user's DeleteAt and TransferTo.
+	CancelUserDeletion(username string) error
+	// GetUsersDueForDeletion lists the users whose DeleteAt is before the
+	// given time.
+	GetUsersDueForDeletion(before time.Time) ([]User, error)
+	// TransferURLs makes every link one user made, trashed or not, the other
+	// user's, keeping their clicks and versions.
+	TransferURLs(from, to string) error
+	Link(username, shortened string) error
+	GetURLsOf(username string) ([]Record, error)
+	FindURLOf(username, normalised string) (Record, error)
+	// VerifyOwns reports whether username made short, including links in the
+	// trash.
+	VerifyOwns(username, short string) bool
+	// OwnerOf returns an empty username for links made without logging in.
+	OwnerOf(short string) (string, error)
+	// SaveCampaign replaces any of the user's campaigns with the same name.
+	SaveCampaign(username string, campaign Campaign) error
+	// GetCampaigns returns a user's campaigns sorted by name.
+	GetCampaigns(username string) ([]Campaign, error)
+	DeleteCampaign(username, name string) error
+	NextID() (uint64, error)
+	Close() error
 }
 
-func VerifyOwns(username, short string) bool {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeRead,
-		DatabaseName: databaseName,
-	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return false
-	}
-	defer session.Close()
-
-	data := map[string]interface{}{"username": username, "short": short}
-	res, err := session.Run("MATCH (u:URL {short: $short})<-[r:MADE]-(USER {username:$username}) RETURN u", data)
+func VerifyUser(store Store, username, password string) bool {
+	user, err := store.GetUser(username)
 	if err != nil {
 		return false
 	}
-
-	return res.Next()
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	return err == nil
 }
 
-func DeleteURL(short string) error {
-	sessionConfig := neo4j.SessionConfig{
-		AccessMode:   neo4j.AccessModeWrite,
-		DatabaseName: databaseName,
-	}
-	session, err := driver.NewSession(sessionConfig)
-	if err != nil {
-		return err
+func clicksBetween(clicks []Click, from, to time.Time) []Click {
+	var res []Click
+	for _, click := range clicks {
+		if !click.Time.Before(from) && click.Time.Before(to) {
+			res = append(res, click)
+		}
 	}
-	defer session.Close()
-
-	data := map[string]interface{}{"short": short}
-	_, err = session.Run("MATCH (url:URL {short: $short}) DETACH DELETE url", data)
-	return err
+	return res
 }
 
-func ParseRecord(node neo4j.Node) (Record, error) {
-	props := node.Props()
-
-	short, ok := props["short"]
-	if !ok {
-		return Record{}, fmt.Errorf("short url not found")
-	}
-	long, ok := props["long"]
-	if !ok {
-		return Record{}, fmt.Errorf("long url not found")
-	}
-	created, ok := props["created"]
-	if !ok {
-		return Record{}, fmt.Errorf("created date not found")
+// summariseClicks builds the stats for a link from its individual clicks, for
+// the stores that cannot aggregate in a query.
+func summariseClicks(clicks []Click) ClickStats {
+	var stats ClickStats
+	visitors := make(map[string]bool)
+	for _, click := range clicks {
+		stats.Total++
+		visitors[click.IPHash] = true
+		if click.Time.After(stats.Last) {
+			stats.Last = click.Time
+		}
 	}
-
-	return Record{
-		Short:   short.(string),
-		Long:    long.(string),
-		Created: created.(time.Time),
-	}, nil
+	stats.Unique = int64(len(visitors))
+	return stats
 }
 
-func ParseUser(node neo4j.Node) (User, error) {
-	props := node.Props()
-
-	username, ok := props["username"]
-	if !ok {
-		return User{}, fmt.Errorf("username not found")
-	}
-	password, ok := props["password"]
-	if !ok {
-		return User{}, fmt.Errorf("password not found")
-	}
-	created, ok := props["created"]
-	if !ok {
-		return User{}, fmt.Errorf("created date not found")
+func hashPassword(password string) (string, error) {
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", err
 	}
-
-	return User{
-		Username: username.(string),
-		Password: password.(string),
-		Created:  created.(time.Time),
-	}, nil
+	return string(hashedPass), nil
 }
@@ -0,0 +1,459 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store kept entirely in process memory. It mirrors the
+// Neo4j graph with a map per node type plus a map of MADE edges from short
+// code to owning username.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	urls   map[string]Record
+	users  map[string]User
+	owners map[string]string
+	clicks map[string][]Click
+	lastID uint64
+
+	// campaigns maps each username to their campaigns by name.
+	campaigns map[string]map[string]Campaign
+	versions  map[string][]Version
+	trash     map[string]Record
+
+	archived []archivedRecord
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		urls:      make(map[string]Record),
+		users:     make(map[string]User),
+		owners:    make(map[string]string),
+		clicks:    make(map[string][]Click),
+		campaigns: make(map[string]map[string]Campaign),
+		versions:  make(map[string][]Version),
+		trash:     make(map[string]Record),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) AddURL(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, live := s.urls[record.Short]
+	_, trashed := s.trash[record.Short]
+	if live || trashed {
+		return ErrURLTaken
+	}
+	record.Created = time.Now()
+	record.Clicks = 0
+	s.urls[record.Short] = withOwnSlices(record)
+	return nil
+}
+
+func (s *MemoryStore) GetUrl(short string) (Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.urls[short]
+	if !ok {
+		return Record{}, fmt.Errorf("url not found")
+	}
+	return record, nil
+}
+
+func (s *MemoryStore) UpdateURL(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.urls[record.Short]
+	if !ok {
+		return fmt.Errorf("url not found")
+	}
+	record.Created = existing.Created
+	record.Clicks = existing.Clicks
+	s.versions[record.Short] = append(s.versions[record.Short], Version{
+		Number:     len(s.versions[record.Short]) + 1,
+		Record:     existing,
+		ReplacedAt: time.Now(),
+	})
+	s.urls[record.Short] = withOwnSlices(record)
+	return nil
+}
+
+func (s *MemoryStore) GetVersions(short string) ([]Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := s.versions[short]
+	res := make([]Version, len(versions))
+	for i, version := range versions {
+		res[len(versions)-1-i] = version
+	}
+	return res, nil
+}
+
+// withOwnSlices copies the record's slices so that the stored record is not
+// changed by whoever passed it in modifying theirs afterwards.
+func withOwnSlices(record Record) Record {
+	record.Rules = append([]TargetRule(nil), record.Rules...)
+	record.Variants = append([]Variant(nil), record.Variants...)
+	return record
+}
+
+func (s *MemoryStore) DeleteURL(short string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.urls, short)
+	delete(s.owners, short)
+	delete(s.clicks, short)
+	delete(s.versions, short)
+	delete(s.trash, short)
+	return nil
+}
+
+func (s *MemoryStore) TrashURL(short string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.urls[short]
+	if !ok {
+		return fmt.Errorf("url not found")
+	}
+	record.DeletedAt = now
+	s.trash[short] = record
+	delete(s.urls, short)
+	return nil
+}
+
+func (s *MemoryStore) RestoreURL(short string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.trash[short]
+	if !ok {
+		return fmt.Errorf("url not found")
+	}
+	record.DeletedAt = time.Time{}
+	s.urls[short] = record
+	delete(s.trash, short)
+	return nil
+}
+
+func (s *MemoryStore) GetTrashOf(username string) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []Record
+	for short, record := range s.trash {
+		if s.owners[short] == username {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].DeletedAt.After(records[j].DeletedAt)
+	})
+	return records, nil
+}
+
+func (s *MemoryStore) PurgeTrash(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for short, record := range s.trash {
+		if !record.DeletedAt.Before(before) {
+			continue
+		}
+		delete(s.trash, short)
+		delete(s.owners, short)
+		delete(s.clicks, short)
+		delete(s.versions, short)
+		count++
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) RecordClicks(clicks []Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, click := range clicks {
+		record, ok := s.urls[click.Short]
+		if !ok {
+			continue
+		}
+		record.Clicks++
+		s.urls[click.Short] = record
+		s.clicks[click.Short] = append(s.clicks[click.Short], click)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetClicks(short string, from, to time.Time) ([]Click, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return clicksBetween(s.clicks[short], from, to), nil
+}
+
+func (s *MemoryStore) ClickStatsOf(username string) (map[string]ClickStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make(map[string]ClickStats)
+	for short, owner := range s.owners {
+		_, live := s.urls[short]
+		if owner == username && live {
+			stats[short] = summariseClicks(s.clicks[short])
+		}
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) SweepExpired(now time.Time, archive bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for short, record := range s.urls {
+		if !record.Expired(now) {
+			continue
+		}
+		if archive {
+			s.archived = append(s.archived, archivedRecord{
+				Record:   record,
+				Owner:    s.owners[short],
+				Clicks:   s.clicks[short],
+				Versions: s.versions[short],
+			})
+		}
+		delete(s.urls, short)
+		delete(s.owners, short)
+		delete(s.clicks, short)
+		delete(s.versions, short)
+		count++
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) AddUser(username, password string) error {
+	hashedPass, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[username]
+	if ok {
+		return ErrUserTaken
+	}
+	s.users[username] = User{
+		Username: username,
+		Password: hashedPass,
+		Created:  time.Now(),
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetUser(username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return User{}, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for short, owner := range s.owners {
+		if owner == username {
+			delete(s.urls, short)
+			delete(s.owners, short)
+			delete(s.clicks, short)
+			delete(s.versions, short)
+			delete(s.trash, short)
+		}
+	}
+	var archived []archivedRecord
+	for _, record := range s.archived {
+		if record.Owner != username {
+			archived = append(archived, record)
+		}
+	}
+	s.archived = archived
+	delete(s.users, username)
+	delete(s.campaigns, username)
+	return nil
+}
+
+func (s *MemoryStore) ScheduleUserDeletion(username string, at time.Time, transferTo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	user.DeleteAt = at
+	user.TransferTo = transferTo
+	s.users[username] = user
+	return nil
+}
+
+func (s *MemoryStore) CancelUserDeletion(username string) error {
+	return s.ScheduleUserDeletion(username, time.Time{}, "")
+}
+
+func (s *MemoryStore) GetUsersDueForDeletion(before time.Time) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []User
+	for _, user := range s.users {
+		if !user.DeleteAt.IsZero() && user.DeleteAt.Before(before) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (s *MemoryStore) TransferURLs(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[to]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	for short, owner := range s.owners {
+		if owner == from {
+			s.owners[short] = to
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Link(username, shortened string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[username]
+	if !ok {
+		return nil
+	}
+	_, ok = s.urls[shortened]
+	if !ok {
+		return nil
+	}
+	s.owners[shortened] = username
+	return nil
+}
+
+func (s *MemoryStore) GetURLsOf(username string) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []Record
+	for short, owner := range s.owners {
+		record, ok := s.urls[short]
+		if ok && owner == username {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	return records, nil
+}
+
+func (s *MemoryStore) FindURLOf(username, normalised string) (Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for short, owner := range s.owners {
+		record, ok := s.urls[short]
+		if ok && owner == username && record.Normalised == normalised {
+			return record, nil
+		}
+	}
+	return Record{}, fmt.Errorf("url not found")
+}
+
+func (s *MemoryStore) VerifyOwns(username, short string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owner, ok := s.owners[short]
+	return ok && owner == username
+}
+
+func (s *MemoryStore) OwnerOf(short string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.urls[short]
+	if !ok {
+		return "", fmt.Errorf("url not found")
+	}
+	return s.owners[short], nil
+}
+
+func (s *MemoryStore) SaveCampaign(username string, campaign Campaign) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	if s.campaigns[username] == nil {
+		s.campaigns[username] = make(map[string]Campaign)
+	}
+	s.campaigns[username][campaign.Name] = campaign
+	return nil
+}
+
+func (s *MemoryStore) GetCampaigns(username string) ([]Campaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var campaigns []Campaign
+	for _, campaign := range s.campaigns[username] {
+		campaigns = append(campaigns, campaign)
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		return campaigns[i].Name < campaigns[j].Name
+	})
+	return campaigns, nil
+}
+
+func (s *MemoryStore) DeleteCampaign(username, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.campaigns[username], name)
+	return nil
+}
+
+func (s *MemoryStore) NextID() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	return s.lastID, nil
+}
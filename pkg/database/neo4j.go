@@ -0,0 +1,1083 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"strings"
+	"time"
+)
+
+const (
+	serverURL    = "bolt://localhost:7687"
+	databaseName = "neo4j"
+)
+
+var constraints = []string{
+	"CREATE CONSTRAINT url_short IF NOT EXISTS ON (u:URL) ASSERT u.short IS UNIQUE",
+	"CREATE CONSTRAINT trashed_url_short IF NOT EXISTS ON (u:TRASHED_URL) ASSERT u.short IS UNIQUE",
+	"CREATE CONSTRAINT user_username IF NOT EXISTS ON (u:USER) ASSERT u.username IS UNIQUE",
+}
+
+// Neo4jStore is a Store backed by a Neo4j instance, with users and URLs as
+// nodes joined by MADE relationships.
+type Neo4jStore struct {
+	driver neo4j.Driver
+}
+
+func NewNeo4jStore(username, password string) (*Neo4jStore, error) {
+	d, err := neo4j.NewDriver(serverURL, neo4j.BasicAuth(username, password, ""), func(config *neo4j.Config) {
+		config.Encrypted = false
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &Neo4jStore{driver: d}
+	err = s.createConstraints()
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Neo4jStore) createConstraints() error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	for _, constraint := range constraints {
+		res, err := session.Run(constraint, nil)
+		if err != nil {
+			return err
+		}
+		_, err = res.Consume()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Neo4jStore) Close() error {
+	return s.driver.Close()
+}
+
+func (s *Neo4jStore) AddURL(record Record) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	props, err := recordProps(record)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{"short": record.Short, "props": props}
+	res, err := session.Run("OPTIONAL MATCH (t:TRASHED_URL {short:$short}) WITH t WHERE t IS NULL CREATE (u:URL $props) SET u.created = datetime({ timezone: 'Europe/London' }), u.clicks = 0 RETURN u", data)
+	if err != nil {
+		return err
+	}
+
+	if res.Next() {
+		return nil
+	}
+	err = res.Err()
+	if err == nil || isConstraintError(err) {
+		return ErrURLTaken
+	}
+	return err
+}
+
+func (s *Neo4jStore) UpdateURL(record Record) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	props, err := recordProps(record)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{"short": record.Short, "props": props}
+	res, err := session.Run("MATCH (u:URL {short:$short}) OPTIONAL MATCH (u)-[:PREVIOUS]->(old:URLVersion) WITH u, count(old) AS versions CREATE (u)-[:PREVIOUS]->(v:URLVersion) SET v = properties(u), v.number = versions + 1, v.replacedAt = datetime() SET u += $props RETURN u", data)
+	if err != nil {
+		return err
+	}
+
+	if res.Next() {
+		return nil
+	}
+	if res.Err() != nil {
+		return res.Err()
+	}
+	return fmt.Errorf("url not found")
+}
+
+func (s *Neo4jStore) GetVersions(short string) ([]Version, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"short": short}
+	res, err := session.Run("MATCH (:URL {short:$short})-[:PREVIOUS]->(v:URLVersion) RETURN v ORDER BY v.number DESC", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for res.Next() {
+		node := res.Record().GetByIndex(0).(neo4j.Node)
+		version, err := ParseVersion(node)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, res.Err()
+}
+
+func (s *Neo4jStore) GetUrl(short string) (Record, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return Record{}, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"short": short}
+	res, err := session.Run("MATCH (u:URL {short:$short}) RETURN u LIMIT 1", data)
+	if err != nil {
+		return Record{}, err
+	}
+
+	for res.Next() {
+		node := res.Record().GetByIndex(0).(neo4j.Node)
+		record, err := ParseRecord(node)
+		if err != nil {
+			continue
+		}
+		return record, nil
+	}
+
+	return Record{}, fmt.Errorf("url not found")
+}
+
+func (s *Neo4jStore) GetUser(username string) (User, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return User{}, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username}
+	res, err := session.Run("MATCH (u:USER {username:$username}) RETURN u LIMIT 1", data)
+	if err != nil {
+		return User{}, err
+	}
+
+	for res.Next() {
+		r := res.Record().GetByIndex(0).(neo4j.Node)
+		user, err := ParseUser(r)
+		if err != nil {
+			continue
+		}
+		return user, nil
+	}
+
+	return User{}, fmt.Errorf("user not found")
+}
+
+func (s *Neo4jStore) DeleteUser(username string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username}
+	res, err := session.Run("MATCH (user:USER {username:$username}) OPTIONAL MATCH (user)-[:HAS]->(campaign:CAMPAIGN) WITH user, collect(campaign) AS campaigns OPTIONAL MATCH (user)-[:MADE]->(url) WHERE url:URL OR url:TRASHED_URL OR url:ARCHIVED_URL OPTIONAL MATCH (c:CLICK)-[:ON]->(url) WITH user, campaigns, url, collect(c) AS clicks OPTIONAL MATCH (url)-[:PREVIOUS]->(v:URLVersion) WITH user, campaigns, url, clicks, collect(v) AS versions FOREACH (n IN clicks + versions | DETACH DELETE n) DETACH DELETE url WITH DISTINCT user, campaigns FOREACH (n IN campaigns | DETACH DELETE n) DETACH DELETE user", data)
+	if err != nil {
+		return err
+	}
+
+	_, err = res.Consume()
+	return err
+}
+
+func (s *Neo4jStore) ScheduleUserDeletion(username string, at time.Time, transferTo string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username, "at": at, "transferTo": transferTo}
+	res, err := session.Run("MATCH (u:USER {username:$username}) SET u.deleteAt = $at, u.transferTo = $transferTo RETURN u", data)
+	if err != nil {
+		return err
+	}
+
+	if res.Next() {
+		return nil
+	}
+	if res.Err() != nil {
+		return res.Err()
+	}
+	return fmt.Errorf("user not found")
+}
+
+func (s *Neo4jStore) CancelUserDeletion(username string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username}
+	res, err := session.Run("MATCH (u:USER {username:$username}) REMOVE u.deleteAt, u.transferTo", data)
+	if err != nil {
+		return err
+	}
+
+	_, err = res.Consume()
+	return err
+}
+
+func (s *Neo4jStore) GetUsersDueForDeletion(before time.Time) ([]User, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"before": before}
+	res, err := session.Run("MATCH (u:USER) WHERE u.deleteAt < $before RETURN u", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	for res.Next() {
+		user, err := ParseUser(res.Record().GetByIndex(0).(neo4j.Node))
+		if err != nil {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, res.Err()
+}
+
+func (s *Neo4jStore) TransferURLs(from, to string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"from": from, "to": to}
+	res, err := session.Run("MATCH (to:USER {username:$to}) OPTIONAL MATCH (:USER {username:$from})-[r:MADE]->(url) WHERE url:URL OR url:TRASHED_URL FOREACH (n IN CASE WHEN url IS NULL THEN [] ELSE [url] END | CREATE (to)-[:MADE]->(n)) DELETE r RETURN count(*)", data)
+	if err != nil {
+		return err
+	}
+
+	if !res.Next() {
+		return res.Err()
+	}
+	if res.Record().GetByIndex(0).(int64) == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (s *Neo4jStore) AddUser(username, password string) error {
+	hashedPass, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username, "password": hashedPass}
+	res, err := session.Run("CREATE (u:USER {username:$username, password:$password, created:datetime({ timezone: 'Europe/London' })})", data)
+	if err != nil {
+		return err
+	}
+
+	_, err = res.Consume()
+	if isConstraintError(err) {
+		return ErrUserTaken
+	}
+	return err
+}
+
+func (s *Neo4jStore) Link(username, shortened string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username, "short": shortened}
+	res, err := session.Run("MATCH (u:USER {username:$username}) MATCH (url:URL {short:$short}) CREATE (u)-[r:MADE]->(url)", data)
+	if err != nil {
+		return err
+	}
+
+	return res.Err()
+}
+
+func (s *Neo4jStore) GetURLsOf(username string) ([]Record, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username}
+	res, err := session.Run("MATCH (u:URL)--(USER {username:$username}) RETURN u", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+
+	for res.Next() {
+		r := res.Record().GetByIndex(0).(neo4j.Node)
+		record, err := ParseRecord(r)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *Neo4jStore) FindURLOf(username, normalised string) (Record, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return Record{}, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username, "normalised": normalised}
+	res, err := session.Run("MATCH (:USER {username:$username})-[:MADE]->(u:URL {normalised: $normalised}) RETURN u LIMIT 1", data)
+	if err != nil {
+		return Record{}, err
+	}
+
+	for res.Next() {
+		node := res.Record().GetByIndex(0).(neo4j.Node)
+		record, err := ParseRecord(node)
+		if err != nil {
+			continue
+		}
+		return record, nil
+	}
+
+	return Record{}, fmt.Errorf("url not found")
+}
+
+func (s *Neo4jStore) VerifyOwns(username, short string) bool {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return false
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username, "short": short}
+	res, err := session.Run("MATCH (:USER {username:$username})-[:MADE]->(u) WHERE u.short = $short AND (u:URL OR u:TRASHED_URL) RETURN u LIMIT 1", data)
+	if err != nil {
+		return false
+	}
+
+	return res.Next()
+}
+
+func (s *Neo4jStore) OwnerOf(short string) (string, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"short": short}
+	res, err := session.Run("MATCH (u:URL {short: $short}) OPTIONAL MATCH (u)<-[:MADE]-(owner:USER) RETURN owner.username LIMIT 1", data)
+	if err != nil {
+		return "", err
+	}
+
+	if res.Next() {
+		owner, _ := res.Record().GetByIndex(0).(string)
+		return owner, nil
+	}
+	if res.Err() != nil {
+		return "", res.Err()
+	}
+	return "", fmt.Errorf("url not found")
+}
+
+func (s *Neo4jStore) SaveCampaign(username string, campaign Campaign) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{
+		"username": username,
+		"name":     campaign.Name,
+		"source":   campaign.UTM.Source,
+		"medium":   campaign.UTM.Medium,
+		"campaign": campaign.UTM.Campaign,
+	}
+	res, err := session.Run("MATCH (u:USER {username:$username}) MERGE (u)-[:HAS]->(c:CAMPAIGN {name:$name}) SET c.source = $source, c.medium = $medium, c.campaign = $campaign RETURN c", data)
+	if err != nil {
+		return err
+	}
+
+	if res.Next() {
+		return nil
+	}
+	if res.Err() != nil {
+		return res.Err()
+	}
+	return fmt.Errorf("user not found")
+}
+
+func (s *Neo4jStore) GetCampaigns(username string) ([]Campaign, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username}
+	res, err := session.Run("MATCH (:USER {username:$username})-[:HAS]->(c:CAMPAIGN) RETURN c ORDER BY c.name", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var campaigns []Campaign
+	for res.Next() {
+		node := res.Record().GetByIndex(0).(neo4j.Node)
+		campaign, err := ParseCampaign(node)
+		if err != nil {
+			continue
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, res.Err()
+}
+
+func (s *Neo4jStore) DeleteCampaign(username, name string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username, "name": name}
+	res, err := session.Run("MATCH (:USER {username:$username})-[:HAS]->(c:CAMPAIGN {name:$name}) DETACH DELETE c", data)
+	if err != nil {
+		return err
+	}
+
+	_, err = res.Consume()
+	return err
+}
+
+func (s *Neo4jStore) DeleteURL(short string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"short": short}
+	res, err := session.Run("OPTIONAL MATCH (live:URL {short:$short}) OPTIONAL MATCH (trashed:TRASHED_URL {short:$short}) WITH coalesce(live, trashed) AS url WHERE url IS NOT NULL OPTIONAL MATCH (c:CLICK)-[:ON]->(url) WITH url, collect(c) AS clicks OPTIONAL MATCH (url)-[:PREVIOUS]->(v:URLVersion) WITH url, clicks, collect(v) AS versions FOREACH (n IN clicks + versions | DETACH DELETE n) DETACH DELETE url", data)
+	if err != nil {
+		return err
+	}
+
+	_, err = res.Consume()
+	return err
+}
+
+func (s *Neo4jStore) TrashURL(short string, now time.Time) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"short": short, "now": now}
+	res, err := session.Run("MATCH (u:URL {short:$short}) REMOVE u:URL SET u:TRASHED_URL, u.deletedAt = $now RETURN u", data)
+	if err != nil {
+		return err
+	}
+
+	if res.Next() {
+		return nil
+	}
+	if res.Err() != nil {
+		return res.Err()
+	}
+	return fmt.Errorf("url not found")
+}
+
+func (s *Neo4jStore) RestoreURL(short string) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"short": short}
+	res, err := session.Run("MATCH (u:TRASHED_URL {short:$short}) REMOVE u:TRASHED_URL, u.deletedAt SET u:URL RETURN u", data)
+	if err != nil {
+		return err
+	}
+
+	if res.Next() {
+		return nil
+	}
+	if isConstraintError(res.Err()) {
+		return ErrURLTaken
+	}
+	if res.Err() != nil {
+		return res.Err()
+	}
+	return fmt.Errorf("url not found")
+}
+
+func (s *Neo4jStore) GetTrashOf(username string) ([]Record, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username}
+	res, err := session.Run("MATCH (:USER {username:$username})-[:MADE]->(u:TRASHED_URL) RETURN u ORDER BY u.deletedAt DESC", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for res.Next() {
+		node := res.Record().GetByIndex(0).(neo4j.Node)
+		record, err := ParseRecord(node)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, res.Err()
+}
+
+func (s *Neo4jStore) PurgeTrash(before time.Time) (int, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"before": before}
+	res, err := session.Run("MATCH (u:TRASHED_URL) WHERE u.deletedAt < $before OPTIONAL MATCH (c:CLICK)-[:ON]->(u) WITH u, collect(c) AS clicks OPTIONAL MATCH (u)-[:PREVIOUS]->(v:URLVersion) WITH u, clicks, collect(v) AS versions FOREACH (n IN clicks + versions | DETACH DELETE n) DETACH DELETE u RETURN count(*)", data)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.Next() {
+		return int(res.Record().GetByIndex(0).(int64)), nil
+	}
+	return 0, res.Err()
+}
+
+func (s *Neo4jStore) NextID() (uint64, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	res, err := session.Run("MERGE (c:COUNTER {name:'url'}) ON CREATE SET c.value = 0 SET c.value = c.value + 1 RETURN c.value", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.Next() {
+		return uint64(res.Record().GetByIndex(0).(int64)), nil
+	}
+	if res.Err() != nil {
+		return 0, res.Err()
+	}
+	return 0, fmt.Errorf("counter not found")
+}
+
+func (s *Neo4jStore) RecordClicks(clicks []Click) error {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var rows []interface{}
+	for _, click := range clicks {
+		rows = append(rows, map[string]interface{}{
+			"short":     click.Short,
+			"time":      click.Time,
+			"referer":   click.Referer,
+			"userAgent": click.UserAgent,
+			"ipHash":    click.IPHash,
+			"country":   click.Country,
+			"city":      click.City,
+			"variant":   click.Variant,
+		})
+	}
+	data := map[string]interface{}{"clicks": rows}
+	res, err := session.Run("UNWIND $clicks AS click MATCH (u:URL {short:click.short}) SET u.clicks = coalesce(u.clicks, 0) + 1 CREATE (c:CLICK {time:click.time, referer:click.referer, userAgent:click.userAgent, ipHash:click.ipHash, country:click.country, city:click.city, variant:click.variant})-[:ON]->(u)", data)
+	if err != nil {
+		return err
+	}
+
+	_, err = res.Consume()
+	return err
+}
+
+func (s *Neo4jStore) GetClicks(short string, from, to time.Time) ([]Click, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"short": short, "from": from, "to": to}
+	res, err := session.Run("MATCH (c:CLICK)-[:ON]->(u:URL {short:$short}) WHERE c.time >= $from AND c.time < $to RETURN c ORDER BY c.time", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var clicks []Click
+
+	for res.Next() {
+		node := res.Record().GetByIndex(0).(neo4j.Node)
+		click, err := ParseClick(node)
+		if err != nil {
+			continue
+		}
+		click.Short = short
+		clicks = append(clicks, click)
+	}
+
+	return clicks, res.Err()
+}
+
+func (s *Neo4jStore) ClickStatsOf(username string) (map[string]ClickStats, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	data := map[string]interface{}{"username": username}
+	res, err := session.Run("MATCH (:USER {username:$username})-[:MADE]->(u:URL) OPTIONAL MATCH (c:CLICK)-[:ON]->(u) RETURN u.short, count(c), count(DISTINCT c.ipHash), max(c.time)", data)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]ClickStats)
+
+	for res.Next() {
+		values := res.Record().Values()
+		short, ok := values[0].(string)
+		if !ok {
+			continue
+		}
+		stat := ClickStats{
+			Total:  values[1].(int64),
+			Unique: values[2].(int64),
+		}
+		if last, ok := values[3].(time.Time); ok {
+			stat.Last = last
+		}
+		stats[short] = stat
+	}
+
+	return stats, res.Err()
+}
+
+func (s *Neo4jStore) SweepExpired(now time.Time, archive bool) (int, error) {
+	sessionConfig := neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: databaseName,
+	}
+	session, err := s.driver.NewSession(sessionConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	query := "MATCH (u:URL) WHERE u.expiresAt <= $now OR (u.maxClicks > 0 AND u.clicks >= u.maxClicks) OPTIONAL MATCH (c:CLICK)-[:ON]->(u) WITH u, collect(c) AS clicks OPTIONAL MATCH (u)-[:PREVIOUS]->(v:URLVersion) WITH u, clicks, collect(v) AS versions FOREACH (n IN clicks + versions | DETACH DELETE n) DETACH DELETE u RETURN count(*)"
+	if archive {
+		query = "MATCH (u:URL) WHERE u.expiresAt <= $now OR (u.maxClicks > 0 AND u.clicks >= u.maxClicks) REMOVE u:URL SET u:ARCHIVED_URL RETURN count(u)"
+	}
+	data := map[string]interface{}{"now": now}
+	res, err := session.Run(query, data)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.Next() {
+		return int(res.Record().GetByIndex(0).(int64)), nil
+	}
+	return 0, res.Err()
+}
+
+// recordProps gives the settable properties of a URL node. Unset optional
+// settings are nil, so that SET += removes them from an existing node. Rules
+// and variants are kept as JSON strings since node properties cannot hold maps.
+func recordProps(record Record) (map[string]interface{}, error) {
+	props := map[string]interface{}{
+		"short":          record.Short,
+		"long":           record.Long,
+		"normalised":     nil,
+		"expiresAt":      nil,
+		"maxClicks":      record.MaxClicks,
+		"rules":          nil,
+		"variants":       nil,
+		"password":       nil,
+		"interstitial":   nil,
+		"redirectStatus": nil,
+		"passthrough":    nil,
+		"utmSource":      nil,
+		"utmMedium":      nil,
+		"utmCampaign":    nil,
+		"editedAt":       nil,
+		"editedBy":       nil,
+	}
+	if record.Normalised != "" {
+		props["normalised"] = record.Normalised
+	}
+	if !record.ExpiresAt.IsZero() {
+		props["expiresAt"] = record.ExpiresAt
+	}
+	if record.Password != "" {
+		props["password"] = record.Password
+	}
+	if record.Interstitial {
+		props["interstitial"] = true
+	}
+	if record.RedirectStatus != 0 {
+		props["redirectStatus"] = record.RedirectStatus
+	}
+	if record.Passthrough {
+		props["passthrough"] = true
+	}
+	if !record.EditedAt.IsZero() {
+		props["editedAt"] = record.EditedAt
+		props["editedBy"] = record.EditedBy
+	}
+	for name, value := range map[string]string{
+		"utmSource":   record.UTM.Source,
+		"utmMedium":   record.UTM.Medium,
+		"utmCampaign": record.UTM.Campaign,
+	} {
+		if value != "" {
+			props[name] = value
+		}
+	}
+	if len(record.Rules) > 0 {
+		rules, err := json.Marshal(record.Rules)
+		if err != nil {
+			return nil, err
+		}
+		props["rules"] = string(rules)
+	}
+	if len(record.Variants) > 0 {
+		variants, err := json.Marshal(record.Variants)
+		if err != nil {
+			return nil, err
+		}
+		props["variants"] = string(variants)
+	}
+	return props, nil
+}
+
+func isConstraintError(err error) bool {
+	return neo4j.IsClientError(err) && strings.Contains(err.Error(), "ConstraintValidationFailed")
+}
+
+func ParseRecord(node neo4j.Node) (Record, error) {
+	props := node.Props()
+
+	short, ok := props["short"]
+	if !ok {
+		return Record{}, fmt.Errorf("short url not found")
+	}
+	long, ok := props["long"]
+	if !ok {
+		return Record{}, fmt.Errorf("long url not found")
+	}
+	created, ok := props["created"]
+	if !ok {
+		return Record{}, fmt.Errorf("created date not found")
+	}
+
+	record := Record{
+		Short:   short.(string),
+		Long:    long.(string),
+		Created: created.(time.Time),
+	}
+	if expiresAt, ok := props["expiresAt"].(time.Time); ok {
+		record.ExpiresAt = expiresAt
+	}
+	if maxClicks, ok := props["maxClicks"].(int64); ok {
+		record.MaxClicks = maxClicks
+	}
+	if clicks, ok := props["clicks"].(int64); ok {
+		record.Clicks = clicks
+	}
+	if password, ok := props["password"].(string); ok {
+		record.Password = password
+	}
+	if interstitial, ok := props["interstitial"].(bool); ok {
+		record.Interstitial = interstitial
+	}
+	if redirectStatus, ok := props["redirectStatus"].(int64); ok {
+		record.RedirectStatus = int(redirectStatus)
+	}
+	if passthrough, ok := props["passthrough"].(bool); ok {
+		record.Passthrough = passthrough
+	}
+	if deletedAt, ok := props["deletedAt"].(time.Time); ok {
+		record.DeletedAt = deletedAt
+	}
+	if editedAt, ok := props["editedAt"].(time.Time); ok {
+		record.EditedAt = editedAt
+	}
+	record.EditedBy, _ = props["editedBy"].(string)
+	record.Normalised, _ = props["normalised"].(string)
+	record.UTM.Source, _ = props["utmSource"].(string)
+	record.UTM.Medium, _ = props["utmMedium"].(string)
+	record.UTM.Campaign, _ = props["utmCampaign"].(string)
+	if rules, ok := props["rules"].(string); ok {
+		err := json.Unmarshal([]byte(rules), &record.Rules)
+		if err != nil {
+			return Record{}, err
+		}
+	}
+	if variants, ok := props["variants"].(string); ok {
+		err := json.Unmarshal([]byte(variants), &record.Variants)
+		if err != nil {
+			return Record{}, err
+		}
+	}
+	return record, nil
+}
+
+func ParseVersion(node neo4j.Node) (Version, error) {
+	props := node.Props()
+
+	number, ok := props["number"].(int64)
+	if !ok {
+		return Version{}, fmt.Errorf("version number not found")
+	}
+	replacedAt, ok := props["replacedAt"].(time.Time)
+	if !ok {
+		return Version{}, fmt.Errorf("version date not found")
+	}
+	record, err := ParseRecord(node)
+	if err != nil {
+		return Version{}, err
+	}
+	return Version{Number: int(number), Record: record, ReplacedAt: replacedAt}, nil
+}
+
+func ParseClick(node neo4j.Node) (Click, error) {
+	props := node.Props()
+
+	clickTime, ok := props["time"].(time.Time)
+	if !ok {
+		return Click{}, fmt.Errorf("click time not found")
+	}
+
+	click := Click{Time: clickTime}
+	click.Referer, _ = props["referer"].(string)
+	click.UserAgent, _ = props["userAgent"].(string)
+	click.IPHash, _ = props["ipHash"].(string)
+	click.Country, _ = props["country"].(string)
+	click.City, _ = props["city"].(string)
+	click.Variant, _ = props["variant"].(string)
+	return click, nil
+}
+
+func ParseCampaign(node neo4j.Node) (Campaign, error) {
+	props := node.Props()
+
+	name, ok := props["name"].(string)
+	if !ok {
+		return Campaign{}, fmt.Errorf("campaign name not found")
+	}
+
+	campaign := Campaign{Name: name}
+	campaign.UTM.Source, _ = props["source"].(string)
+	campaign.UTM.Medium, _ = props["medium"].(string)
+	campaign.UTM.Campaign, _ = props["campaign"].(string)
+	return campaign, nil
+}
+
+func ParseUser(node neo4j.Node) (User, error) {
+	props := node.Props()
+
+	username, ok := props["username"]
+	if !ok {
+		return User{}, fmt.Errorf("username not found")
+	}
+	password, ok := props["password"]
+	if !ok {
+		return User{}, fmt.Errorf("password not found")
+	}
+	created, ok := props["created"]
+	if !ok {
+		return User{}, fmt.Errorf("created date not found")
+	}
+
+	user := User{
+		Username: username.(string),
+		Password: password.(string),
+		Created:  created.(time.Time),
+	}
+	if deleteAt, ok := props["deleteAt"].(time.Time); ok {
+		user.DeleteAt = deleteAt
+		user.TransferTo, _ = props["transferTo"].(string)
+	}
+	return user, nil
+}
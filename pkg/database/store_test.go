@@ -0,0 +1,422 @@
+package database
+
+import (
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStores builds a fresh instance of every Store that can run without an
+// external server, so each test runs against all of them alike.
+var newStores = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return NewMemoryStore()
+	},
+	"bolt": func(t *testing.T) Store {
+		s, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	},
+}
+
+var storeTests = []struct {
+	name string
+	test func(t *testing.T, s Store)
+}{
+	{"unique keys", testUniqueKeys},
+	{"ownership", testOwnership},
+	{"versions", testVersions},
+	{"clicks", testClicks},
+	{"trash", testTrash},
+	{"sweep", testSweep},
+	{"archive", testArchive},
+	{"campaigns", testCampaigns},
+	{"delete user", testDeleteUser},
+	{"scheduled deletion", testScheduledDeletion},
+	{"next id", testNextID},
+}
+
+func TestStores(t *testing.T) {
+	for storeName, newStore := range newStores {
+		for _, tt := range storeTests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				tt.test(t, newStore(t))
+			})
+		}
+	}
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// archivedIn returns the records a store has swept aside with archive set.
+func archivedIn(t *testing.T, s Store) []archivedRecord {
+	t.Helper()
+	switch s := s.(type) {
+	case *MemoryStore:
+		return s.archived
+	case *BoltStore:
+		var archived []archivedRecord
+		mustDo(t, s.db.View(func(tx *bbolt.Tx) error {
+			return tx.Bucket(archivedBucket).ForEach(func(k, v []byte) error {
+				var record archivedRecord
+				err := json.Unmarshal(v, &record)
+				archived = append(archived, record)
+				return err
+			})
+		}))
+		return archived
+	}
+	t.Fatalf("cannot look at the archive of a %T", s)
+	return nil
+}
+
+// addLink stores a link to long under short, made by username unless it is
+// empty.
+func addLink(t *testing.T, s Store, username, short, long string) {
+	t.Helper()
+	mustDo(t, s.AddURL(Record{Short: short, Long: long}))
+	if username != "" {
+		mustDo(t, s.Link(username, short))
+	}
+}
+
+func testUniqueKeys(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	if err := s.AddUser("bob", "other"); err != ErrUserTaken {
+		t.Fatalf("adding bob twice gave %v", err)
+	}
+	if !VerifyUser(s, "bob", "pw") || VerifyUser(s, "bob", "other") {
+		t.Fatal("bob's password was not kept")
+	}
+
+	mustDo(t, s.AddURL(Record{Short: "abc", Long: "https://example.com", Clicks: 5}))
+	if err := s.AddURL(Record{Short: "abc", Long: "https://other.example"}); err != ErrURLTaken {
+		t.Fatalf("adding abc twice gave %v", err)
+	}
+	record, err := s.GetUrl("abc")
+	mustDo(t, err)
+	if record.Long != "https://example.com" || record.Clicks != 0 || record.Created.IsZero() {
+		t.Fatalf("got %+v", record)
+	}
+	if _, err := s.GetUrl("missing"); err == nil {
+		t.Fatal("found a link that was never added")
+	}
+}
+
+func testOwnership(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	addLink(t, s, "bob", "first", "https://example.com/1")
+	mustDo(t, s.AddURL(Record{Short: "second", Long: "https://Example.com/2/", Normalised: "https://example.com/2"}))
+	mustDo(t, s.Link("bob", "second"))
+	addLink(t, s, "", "anon", "https://example.com/1")
+
+	if !s.VerifyOwns("bob", "first") || s.VerifyOwns("bob", "anon") || s.VerifyOwns("alice", "first") {
+		t.Fatal("ownership was not kept")
+	}
+	owner, err := s.OwnerOf("first")
+	if err != nil || owner != "bob" {
+		t.Fatalf("got owner %q, %v", owner, err)
+	}
+	owner, err = s.OwnerOf("anon")
+	if err != nil || owner != "" {
+		t.Fatalf("got owner %q, %v for an anonymous link", owner, err)
+	}
+
+	records, err := s.GetURLsOf("bob")
+	mustDo(t, err)
+	if len(records) != 2 {
+		t.Fatalf("got %d links for bob, want 2", len(records))
+	}
+	record, err := s.FindURLOf("bob", "https://example.com/2")
+	if err != nil || record.Short != "second" || record.Long != "https://Example.com/2/" {
+		t.Fatalf("got %+v, %v", record, err)
+	}
+	if _, err := s.FindURLOf("bob", "https://example.com/3"); err == nil {
+		t.Fatal("found a link bob never made")
+	}
+}
+
+func testVersions(t *testing.T, s Store) {
+	addLink(t, s, "", "abc", "https://example.com/1")
+	mustDo(t, s.RecordClicks([]Click{{Short: "abc", Time: time.Now()}}))
+	before, err := s.GetUrl("abc")
+	mustDo(t, err)
+
+	for _, long := range []string{"https://example.com/2", "https://example.com/3"} {
+		record, err := s.GetUrl("abc")
+		mustDo(t, err)
+		record.Long = long
+		mustDo(t, s.UpdateURL(record))
+	}
+	if err := s.UpdateURL(Record{Short: "missing"}); err == nil {
+		t.Fatal("updated a link that does not exist")
+	}
+
+	record, err := s.GetUrl("abc")
+	mustDo(t, err)
+	if record.Long != "https://example.com/3" || record.Clicks != 1 || !record.Created.Equal(before.Created) {
+		t.Fatalf("got %+v", record)
+	}
+	versions, err := s.GetVersions("abc")
+	mustDo(t, err)
+	if len(versions) != 2 || versions[0].Number != 2 || versions[0].Record.Long != "https://example.com/2" || versions[1].Record.Long != "https://example.com/1" {
+		t.Fatalf("got versions %+v", versions)
+	}
+
+	mustDo(t, s.DeleteURL("abc"))
+	versions, err = s.GetVersions("abc")
+	mustDo(t, err)
+	if len(versions) != 0 {
+		t.Fatal("deleting a link kept its versions")
+	}
+}
+
+func testClicks(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	addLink(t, s, "bob", "abc", "https://example.com")
+	start := time.Now().Add(-time.Hour)
+	mustDo(t, s.RecordClicks([]Click{
+		{Short: "abc", Time: start, IPHash: "a"},
+		{Short: "abc", Time: start.Add(time.Minute), IPHash: "a"},
+		{Short: "abc", Time: start.Add(2 * time.Minute), IPHash: "b"},
+		{Short: "missing", Time: start},
+	}))
+
+	record, err := s.GetUrl("abc")
+	mustDo(t, err)
+	if record.Clicks != 3 {
+		t.Fatalf("got %d clicks, want 3", record.Clicks)
+	}
+	clicks, err := s.GetClicks("abc", start.Add(time.Second), start.Add(time.Hour))
+	mustDo(t, err)
+	if len(clicks) != 2 || clicks[0].Time.After(clicks[1].Time) {
+		t.Fatalf("got clicks %+v", clicks)
+	}
+	stats, err := s.ClickStatsOf("bob")
+	mustDo(t, err)
+	if got := stats["abc"]; got.Total != 3 || got.Unique != 2 || !got.Last.Equal(start.Add(2*time.Minute)) {
+		t.Fatalf("got stats %+v", got)
+	}
+	mustDo(t, s.TrashURL("abc", time.Now()))
+	stats, err = s.ClickStatsOf("bob")
+	mustDo(t, err)
+	if _, ok := stats["abc"]; ok {
+		t.Fatal("stats include a trashed link")
+	}
+
+	mustDo(t, s.DeleteURL("abc"))
+	clicks, err = s.GetClicks("abc", start, start.Add(time.Hour))
+	mustDo(t, err)
+	if len(clicks) != 0 {
+		t.Fatal("deleting a link kept its clicks")
+	}
+}
+
+func testTrash(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	addLink(t, s, "bob", "old", "https://example.com/old")
+	addLink(t, s, "bob", "new", "https://example.com/new")
+	now := time.Now()
+	mustDo(t, s.TrashURL("old", now.Add(-time.Hour)))
+	mustDo(t, s.TrashURL("new", now))
+
+	if _, err := s.GetUrl("old"); err == nil {
+		t.Fatal("a trashed link can still be followed")
+	}
+	if err := s.AddURL(Record{Short: "old", Long: "https://other.example"}); err != ErrURLTaken {
+		t.Fatalf("reusing a trashed code gave %v", err)
+	}
+	if !s.VerifyOwns("bob", "old") {
+		t.Fatal("bob lost ownership of a trashed link")
+	}
+	records, err := s.GetURLsOf("bob")
+	mustDo(t, err)
+	if len(records) != 0 {
+		t.Fatal("trashed links are still listed as live")
+	}
+	trash, err := s.GetTrashOf("bob")
+	mustDo(t, err)
+	if len(trash) != 2 || trash[0].Short != "new" || !trash[1].DeletedAt.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("got trash %+v", trash)
+	}
+
+	mustDo(t, s.RestoreURL("new"))
+	record, err := s.GetUrl("new")
+	if err != nil || !record.DeletedAt.IsZero() {
+		t.Fatalf("got %+v, %v after restoring", record, err)
+	}
+
+	count, err := s.PurgeTrash(now.Add(-time.Minute))
+	mustDo(t, err)
+	if count != 1 {
+		t.Fatalf("purged %d links, want 1", count)
+	}
+	trash, err = s.GetTrashOf("bob")
+	mustDo(t, err)
+	if len(trash) != 0 || s.VerifyOwns("bob", "old") {
+		t.Fatal("purged link is still around")
+	}
+	mustDo(t, s.AddURL(Record{Short: "old", Long: "https://other.example"}))
+}
+
+func testSweep(t *testing.T, s Store) {
+	now := time.Now()
+	mustDo(t, s.AddURL(Record{Short: "dated", Long: "https://example.com", ExpiresAt: now.Add(-time.Minute)}))
+	mustDo(t, s.AddURL(Record{Short: "used", Long: "https://example.com", MaxClicks: 1}))
+	mustDo(t, s.AddURL(Record{Short: "live", Long: "https://example.com", ExpiresAt: now.Add(time.Hour)}))
+	mustDo(t, s.RecordClicks([]Click{{Short: "used", Time: now}}))
+
+	count, err := s.SweepExpired(now, false)
+	mustDo(t, err)
+	if count != 2 {
+		t.Fatalf("swept %d links, want 2", count)
+	}
+	for short, live := range map[string]bool{"dated": false, "used": false, "live": true} {
+		_, err := s.GetUrl(short)
+		if (err == nil) != live {
+			t.Errorf("%s: got error %v", short, err)
+		}
+	}
+}
+
+func testArchive(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	mustDo(t, s.AddUser("alice", "pw"))
+	addLink(t, s, "bob", "bobs", "https://example.com/old")
+	mustDo(t, s.UpdateURL(Record{Short: "bobs", Long: "https://example.com/new", MaxClicks: 1}))
+	addLink(t, s, "alice", "hers", "https://example.com")
+	mustDo(t, s.UpdateURL(Record{Short: "hers", Long: "https://example.com", MaxClicks: 1}))
+	now := time.Now()
+	mustDo(t, s.RecordClicks([]Click{{Short: "bobs", Time: now}, {Short: "hers", Time: now}}))
+
+	count, err := s.SweepExpired(now, true)
+	mustDo(t, err)
+	if count != 2 {
+		t.Fatalf("swept %d links, want 2", count)
+	}
+	archived := archivedIn(t, s)
+	if len(archived) != 2 {
+		t.Fatalf("got archive %+v", archived)
+	}
+	for _, record := range archived {
+		if len(record.Clicks) != 1 || len(record.Versions) != 1 {
+			t.Errorf("archived %s with %d clicks and %d versions", record.Record.Short, len(record.Clicks), len(record.Versions))
+		}
+	}
+
+	mustDo(t, s.DeleteUser("bob"))
+	archived = archivedIn(t, s)
+	if len(archived) != 1 || archived[0].Owner != "alice" {
+		t.Fatalf("got archive %+v after deleting bob", archived)
+	}
+}
+
+func testCampaigns(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	mustDo(t, s.SaveCampaign("bob", Campaign{Name: "spring", UTM: UTM{Source: "news"}}))
+	mustDo(t, s.SaveCampaign("bob", Campaign{Name: "autumn", UTM: UTM{Source: "mail"}}))
+	mustDo(t, s.SaveCampaign("bob", Campaign{Name: "spring", UTM: UTM{Source: "blog"}}))
+	if err := s.SaveCampaign("nobody", Campaign{Name: "spring"}); err == nil {
+		t.Fatal("saved a campaign for a user that does not exist")
+	}
+
+	campaigns, err := s.GetCampaigns("bob")
+	mustDo(t, err)
+	if len(campaigns) != 2 || campaigns[0].Name != "autumn" || campaigns[1].UTM.Source != "blog" {
+		t.Fatalf("got campaigns %+v", campaigns)
+	}
+	mustDo(t, s.DeleteCampaign("bob", "autumn"))
+	campaigns, err = s.GetCampaigns("bob")
+	mustDo(t, err)
+	if len(campaigns) != 1 {
+		t.Fatalf("got campaigns %+v after deleting one", campaigns)
+	}
+}
+
+func testDeleteUser(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	mustDo(t, s.AddUser("alice", "pw"))
+	addLink(t, s, "bob", "live", "https://example.com")
+	addLink(t, s, "bob", "trashed", "https://example.com")
+	addLink(t, s, "alice", "hers", "https://example.com")
+	mustDo(t, s.TrashURL("trashed", time.Now()))
+	mustDo(t, s.SaveCampaign("bob", Campaign{Name: "spring", UTM: UTM{Source: "news"}}))
+
+	mustDo(t, s.DeleteUser("bob"))
+	if _, err := s.GetUser("bob"); err == nil {
+		t.Fatal("bob still exists")
+	}
+	if _, err := s.GetUrl("live"); err == nil {
+		t.Fatal("bob's link still exists")
+	}
+	mustDo(t, s.AddURL(Record{Short: "trashed", Long: "https://example.com"}))
+	campaigns, err := s.GetCampaigns("bob")
+	mustDo(t, err)
+	if len(campaigns) != 0 {
+		t.Fatal("bob's campaigns still exist")
+	}
+	if !s.VerifyOwns("alice", "hers") {
+		t.Fatal("deleting bob touched alice's link")
+	}
+}
+
+func testScheduledDeletion(t *testing.T, s Store) {
+	mustDo(t, s.AddUser("bob", "pw"))
+	mustDo(t, s.AddUser("alice", "pw"))
+	addLink(t, s, "bob", "live", "https://example.com")
+	addLink(t, s, "bob", "trashed", "https://example.com")
+	mustDo(t, s.TrashURL("trashed", time.Now()))
+
+	now := time.Now()
+	mustDo(t, s.ScheduleUserDeletion("bob", now.Add(time.Hour), "alice"))
+	user, err := s.GetUser("bob")
+	mustDo(t, err)
+	if !user.DeleteAt.Equal(now.Add(time.Hour)) || user.TransferTo != "alice" {
+		t.Fatalf("got %+v", user)
+	}
+	due, err := s.GetUsersDueForDeletion(now)
+	mustDo(t, err)
+	if len(due) != 0 {
+		t.Fatalf("got %d users due before their time", len(due))
+	}
+	due, err = s.GetUsersDueForDeletion(now.Add(2 * time.Hour))
+	mustDo(t, err)
+	if len(due) != 1 || due[0].Username != "bob" {
+		t.Fatalf("got due users %+v", due)
+	}
+
+	mustDo(t, s.CancelUserDeletion("bob"))
+	user, err = s.GetUser("bob")
+	mustDo(t, err)
+	if !user.DeleteAt.IsZero() || user.TransferTo != "" {
+		t.Fatalf("got %+v after cancelling", user)
+	}
+
+	if err := s.TransferURLs("bob", "nobody"); err == nil {
+		t.Fatal("transferred links to a user that does not exist")
+	}
+	mustDo(t, s.TransferURLs("bob", "alice"))
+	if !s.VerifyOwns("alice", "live") || !s.VerifyOwns("alice", "trashed") || s.VerifyOwns("bob", "live") {
+		t.Fatal("links were not transferred")
+	}
+}
+
+func testNextID(t *testing.T, s Store) {
+	first, err := s.NextID()
+	mustDo(t, err)
+	second, err := s.NextID()
+	mustDo(t, err)
+	if second <= first {
+		t.Fatalf("got id %d after %d", second, first)
+	}
+}
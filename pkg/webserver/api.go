@@ -0,0 +1,117 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+type apiLinkRequest struct {
+	URL            string    `json:"url"`
+	Short          string    `json:"short"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	MaxClicks      int64     `json:"maxClicks"`
+	Password       string    `json:"password"`
+	RedirectStatus int       `json:"redirectType"`
+	Passthrough    bool      `json:"passthrough"`
+	UTM            apiUTM    `json:"utm"`
+	Campaign       string    `json:"campaign"`
+}
+
+type apiUTM struct {
+	Source   string `json:"source"`
+	Medium   string `json:"medium"`
+	Campaign string `json:"campaign"`
+}
+
+type apiLinkResponse struct {
+	Short string `json:"short"`
+	URL   string `json:"url,omitempty"`
+}
+
+type apiEditRequest struct {
+	URL string `json:"url"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// handleAPICreateLink shortens a URL sent as JSON. Callers with a login cookie
+// own the link, exactly as when using the home page form.
+func (s *server) handleAPICreateLink(res http.ResponseWriter, req *http.Request) {
+	var body apiLinkRequest
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		writeJSON(res, http.StatusBadRequest, apiError{Error: "request body must be a json object"})
+		return
+	}
+
+	link := linkRequest{
+		Long:           body.URL,
+		Requested:      body.Short,
+		ExpiresAt:      body.ExpiresAt,
+		MaxClicks:      body.MaxClicks,
+		Password:       body.Password,
+		RedirectStatus: body.RedirectStatus,
+		Passthrough:    body.Passthrough,
+		UTM:            database.UTM(body.UTM),
+		Campaign:       body.Campaign,
+	}
+	user, err := s.verifyUsernameCookie(res, req)
+	shortened, err := s.createLink(link, user, err == nil)
+	if err != nil {
+		writeJSON(res, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(res, http.StatusCreated, apiLinkResponse{Short: shortened})
+}
+
+// handleAPIEditLink points one of the caller's links at the url sent as JSON,
+// keeping its short code.
+func (s *server) handleAPIEditLink(res http.ResponseWriter, req *http.Request) {
+	shortened := mux.Vars(req)["key"]
+	user, err := s.verifyUsernameCookie(res, req)
+	if err != nil {
+		writeJSON(res, http.StatusUnauthorized, apiError{Error: "you must be logged in to edit links"})
+		return
+	}
+	if !s.store.VerifyOwns(user, shortened) {
+		writeJSON(res, http.StatusForbidden, apiError{Error: "URL not owned by you"})
+		return
+	}
+
+	var body apiEditRequest
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		writeJSON(res, http.StatusBadRequest, apiError{Error: "request body must be a json object"})
+		return
+	}
+
+	record, err := s.store.GetUrl(shortened)
+	if err != nil {
+		writeJSON(res, http.StatusNotFound, apiError{Error: "shortened url not found"})
+		return
+	}
+	err = s.editDestination(&record, body.URL, user)
+	if err != nil {
+		writeJSON(res, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	err = s.store.UpdateURL(record)
+	if err != nil {
+		writeJSON(res, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(res, http.StatusOK, apiLinkResponse{Short: shortened, URL: record.Long})
+}
+
+func writeJSON(res http.ResponseWriter, status int, body interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(body)
+}
@@ -0,0 +1,137 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+const (
+	campaignNameMaxLength = 64
+	utmValueMaxLength     = 200
+)
+
+// utmFromForm reads the utm_source, utm_medium and utm_campaign fields sent
+// with a form.
+func utmFromForm(form url.Values) database.UTM {
+	return database.UTM{
+		Source:   form.Get("utm_source"),
+		Medium:   form.Get("utm_medium"),
+		Campaign: form.Get("utm_campaign"),
+	}
+}
+
+// checkUTM trims the parameters and rejects any that are too long.
+func checkUTM(utm database.UTM) (database.UTM, error) {
+	utm.Source = strings.TrimSpace(utm.Source)
+	utm.Medium = strings.TrimSpace(utm.Medium)
+	utm.Campaign = strings.TrimSpace(utm.Campaign)
+	for _, value := range []string{utm.Source, utm.Medium, utm.Campaign} {
+		if len(value) > utmValueMaxLength {
+			return database.UTM{}, fmt.Errorf("UTM parameters may be at most %d characters long", utmValueMaxLength)
+		}
+	}
+	return utm, nil
+}
+
+// applyCampaign fills in the parameters of utm left empty from the user's
+// campaign called name, so a campaign can be used with some of its
+// parameters overridden. An empty name leaves utm as it is.
+func (s *server) applyCampaign(utm database.UTM, user, name string) (database.UTM, error) {
+	if name == "" {
+		return utm, nil
+	}
+	campaigns, err := s.store.GetCampaigns(user)
+	if err != nil {
+		return database.UTM{}, err
+	}
+	for _, campaign := range campaigns {
+		if campaign.Name != name {
+			continue
+		}
+		if utm.Source == "" {
+			utm.Source = campaign.UTM.Source
+		}
+		if utm.Medium == "" {
+			utm.Medium = campaign.UTM.Medium
+		}
+		if utm.Campaign == "" {
+			utm.Campaign = campaign.UTM.Campaign
+		}
+		return utm, nil
+	}
+	return database.UTM{}, fmt.Errorf("campaign %s not found", name)
+}
+
+// withUTM adds the link's UTM parameters to destination. Parameters the
+// destination already sets are left alone.
+func withUTM(destination string, utm database.UTM) string {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	existing := u.Query()
+	extra := url.Values{}
+	for key, value := range map[string]string{
+		"utm_source":   utm.Source,
+		"utm_medium":   utm.Medium,
+		"utm_campaign": utm.Campaign,
+	} {
+		_, ok := existing[key]
+		if value != "" && !ok {
+			extra.Set(key, value)
+		}
+	}
+	if len(extra) > 0 {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += extra.Encode()
+	}
+	return u.String()
+}
+
+// handleCampaignsUpdate adds or removes one of the user's campaign templates,
+// depending on the action sent with the form.
+func (s *server) handleCampaignsUpdate(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	user, _ := s.verifyUsernameCookie(res, req)
+
+	var err error
+	switch req.Form.Get("action") {
+	case "add":
+		err = s.saveCampaign(user, req.Form.Get("name"), utmFromForm(req.Form))
+	case "delete":
+		err = s.store.DeleteCampaign(user, req.Form.Get("name"))
+	default:
+		err = fmt.Errorf("unknown action")
+	}
+	if err != nil {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   err.Error(),
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+	}
+	http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+}
+
+func (s *server) saveCampaign(user, name string, utm database.UTM) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > campaignNameMaxLength {
+		return fmt.Errorf("campaign names must be between 1 and %d characters long", campaignNameMaxLength)
+	}
+	utm, err := checkUTM(utm)
+	if err != nil {
+		return err
+	}
+	if utm.IsZero() {
+		return fmt.Errorf("campaigns must set at least one UTM parameter")
+	}
+	return s.store.SaveCampaign(user, database.Campaign{Name: name, UTM: utm})
+}
@@ -0,0 +1,171 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+// newClick describes the visitor following short in req. The client address
+// is located with the GeoIP database, if there is one, and then only kept as
+// an HMAC under the server secret so that unique visitors can be counted
+// without storing IPs.
+func (s *server) newClick(req *http.Request, short string) database.Click {
+	ip := clientIP(req)
+	country, city := s.locate(ip)
+	return database.Click{
+		Short:     short,
+		Time:      time.Now(),
+		Referer:   req.Referer(),
+		UserAgent: req.UserAgent(),
+		IPHash:    s.hashClientIP(ip),
+		Country:   country,
+		City:      city,
+	}
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (s *server) hashClientIP(ip string) string {
+	mac := hmac.New(sha256.New, s.jwtSecret)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// ClickConfig sizes the pipeline that writes clicks to the store. With a zero
+// BufferSize every click is written synchronously inside the redirect.
+type ClickConfig struct {
+	BufferSize    int
+	Workers       int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// clickRecorder buffers clicks on a bounded channel and has a pool of workers
+// write them to the store in batches, so redirects never wait on the store.
+// Clicks arriving while the buffer is full are dropped and counted rather
+// than slowing the redirect down.
+type clickRecorder struct {
+	dropped uint64
+	config  ClickConfig
+	store   database.Store
+	events  chan database.Click
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newClickRecorder(config ClickConfig, store database.Store) *clickRecorder {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+
+	r := &clickRecorder{
+		config: config,
+		store:  store,
+		events: make(chan database.Click, config.BufferSize),
+	}
+	for i := 0; i < config.Workers; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+	return r
+}
+
+// recordClick stores click, through the queue when there is one. Clicks on
+// links with a click limit skip the queue so the limit is enforced exactly.
+func (s *server) recordClick(click database.Click, record database.Record) {
+	if s.clickQueue == nil || record.MaxClicks > 0 {
+		err := s.store.RecordClicks([]database.Click{click})
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	s.clickQueue.enqueue(click)
+}
+
+func (r *clickRecorder) enqueue(click database.Click) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		atomic.AddUint64(&r.dropped, 1)
+		return
+	}
+	select {
+	case r.events <- click:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+// close stops accepting clicks and waits for the workers to flush everything
+// still buffered.
+func (r *clickRecorder) close() {
+	r.mu.Lock()
+	r.closed = true
+	close(r.events)
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	dropped := atomic.LoadUint64(&r.dropped)
+	if dropped > 0 {
+		fmt.Printf("dropped %d clicks while the click buffer was full\n", dropped)
+	}
+}
+
+func (r *clickRecorder) work() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]database.Click, 0, r.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := r.store.RecordClicks(batch)
+		if err != nil {
+			fmt.Println(err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case click, ok := <-r.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, click)
+			if len(batch) >= r.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package webserver
+
+import "time"
+
+// Config holds the server-wide settings chosen at startup.
+type Config struct {
+	Secret       string
+	ShortCodes   ShortCodeConfig
+	CustomCodes  CustomCodeConfig
+	Destinations DestinationConfig
+	Clicks       ClickConfig
+	// GeoIPDatabase is the path of a MaxMind-format .mmdb file used to
+	// locate clicks. Clicks are recorded without a location when it is empty.
+	GeoIPDatabase string
+	// Dedupe hands a logged in user their existing short code when they
+	// shorten the same URL again, comparing URLs in normalised form. Links
+	// still go to the URL exactly as it was entered.
+	Dedupe bool
+	// SweepInterval is how often expired links are cleared out of the store,
+	// with zero disabling the sweep. ArchiveExpired keeps swept links aside
+	// instead of deleting them.
+	SweepInterval  time.Duration
+	ArchiveExpired bool
+	// TrashRetention is how long deleted links stay in the trash before they
+	// are purged, with zero keeping them until purged by hand.
+	TrashRetention time.Duration
+	// DeletionGrace is how long accounts wait to be deleted after the user
+	// asks, during which logging in cancels the deletion. Zero deletes them
+	// straight away. Deletions are carried out by the sweep, so a grace
+	// period with the sweep disabled keeps accounts until it is enabled.
+	DeletionGrace time.Duration
+	// RedirectStatus is used for links that do not pick their own redirect
+	// type, and defaults to DefaultRedirectStatus.
+	RedirectStatus int
+}
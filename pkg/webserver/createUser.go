@@ -12,9 +12,9 @@ type createUserInformation struct {
 	Error         string
 }
 
-const createUserTemplateLocation = "pkg/webserver/templates/createUser.html"
+const createUserTemplateLocation = "templates/createUser.html"
 
-var createUserTemplate = template.Must(template.ParseFiles(createUserTemplateLocation))
+var createUserTemplate = template.Must(template.ParseFS(templates, createUserTemplateLocation))
 
 func showCreateUserPage(res http.ResponseWriter, req *http.Request) {
 	info := new(createUserInformation)
@@ -34,7 +34,7 @@ func showCreateUserPage(res http.ResponseWriter, req *http.Request) {
 	createUserTemplate.Execute(res, info)
 }
 
-func handleCreation(res http.ResponseWriter, req *http.Request) {
+func (s *server) handleCreation(res http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 
 	usernames, ok := req.Form["username"]
@@ -64,11 +64,15 @@ func handleCreation(res http.ResponseWriter, req *http.Request) {
 	username := usernames[0]
 	password := passwords[0]
 
-	err := database.AddUser(username, password)
+	err := s.store.AddUser(username, password)
 	if err != nil {
+		message := err.Error()
+		if err == database.ErrUserTaken {
+			message = "That username is already taken"
+		}
 		http.SetCookie(res, &http.Cookie{
 			Name:    "error",
-			Value:   err.Error(),
+			Value:   message,
 			Expires: time.Now().Add(time.Minute),
 			Path:    routeMain,
 		})
@@ -76,34 +80,7 @@ func handleCreation(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	signInUser(username, res, req)
+	s.signInUser(username, res, req)
 
 	http.Redirect(res, req, routeMain, http.StatusSeeOther)
 }
-
-func handleDeleteUser(res http.ResponseWriter, req *http.Request) {
-	username, err := getUsernameCookie(res, req)
-	if err != nil {
-		http.SetCookie(res, &http.Cookie{
-			Name:    "error",
-			Value:   err.Error(),
-			Expires: time.Now().Add(time.Minute),
-			Path:    routeMain,
-		})
-		http.Redirect(res, req, routeMain, http.StatusSeeOther)
-		return
-	}
-	err = database.DeleteUser(username)
-	if err != nil {
-		http.SetCookie(res, &http.Cookie{
-			Name:    "error",
-			Value:   err.Error(),
-			Expires: time.Now().Add(time.Minute),
-			Path:    routeMain,
-		})
-		http.Redirect(res, req, routeMain, http.StatusSeeOther)
-		return
-	}
-	signOutUser(res, req)
-	http.Redirect(res, req, routeMain, http.StatusSeeOther)
-}
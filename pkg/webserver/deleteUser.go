@@ -0,0 +1,171 @@
+package webserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+type deleteUserInformation struct {
+	ErrorHappened bool
+	Error         string
+	LoggedInAs    string
+	DeleteAt      time.Time
+}
+
+const deleteUserTemplateLocation = "templates/deleteUser.html"
+
+var deleteUserTemplate = template.Must(template.ParseFS(templates, deleteUserTemplateLocation))
+
+func (s *server) showDeleteUserPage(res http.ResponseWriter, req *http.Request) {
+	info := new(deleteUserInformation)
+	info.LoggedInAs, _ = s.verifyUsernameCookie(res, req)
+	if s.config.DeletionGrace > 0 {
+		info.DeleteAt = time.Now().Add(s.config.DeletionGrace)
+	}
+
+	errorCookie, err := req.Cookie("error")
+	if err == nil {
+		info.ErrorHappened = true
+		info.Error = errorCookie.Value
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   "",
+			Expires: time.Now(),
+			Path:    routeMain,
+		})
+	}
+
+	deleteUserTemplate.Execute(res, info)
+}
+
+// handleDeleteUser checks the user's password and schedules their account to
+// be deleted once the grace period is up, or deletes it straight away when
+// there is none. Links are given to the user named in transferTo if there is
+// one rather than deleted with the account.
+func (s *server) handleDeleteUser(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	username, _ := s.verifyUsernameCookie(res, req)
+
+	err := s.scheduleDeletion(username, req.Form.Get("password"), strings.TrimSpace(req.Form.Get("transferTo")))
+	if err != nil {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   err.Error(),
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+		http.Redirect(res, req, routeDeleteUser, http.StatusSeeOther)
+		return
+	}
+
+	signOutUser(res, req)
+	if s.config.DeletionGrace > 0 {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "notice",
+			Value:   "Your account will be deleted in " + formatGrace(s.config.DeletionGrace) + ". Log in before then to keep it.",
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+		http.Redirect(res, req, routeLogin, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(res, req, routeMain, http.StatusSeeOther)
+}
+
+func (s *server) scheduleDeletion(username, password, transferTo string) error {
+	if !database.VerifyUser(s.store, username, password) {
+		return fmt.Errorf("Password is incorrect")
+	}
+	if transferTo != "" {
+		if transferTo == username {
+			return fmt.Errorf("Links cannot be transferred to the account being deleted")
+		}
+		recipient, err := s.store.GetUser(transferTo)
+		if err != nil || !recipient.DeleteAt.IsZero() {
+			return fmt.Errorf("There is no user called %s to give your links to", transferTo)
+		}
+	}
+
+	if s.config.DeletionGrace <= 0 {
+		return s.deleteAccount(database.User{Username: username, TransferTo: transferTo})
+	}
+	return s.store.ScheduleUserDeletion(username, time.Now().Add(s.config.DeletionGrace), transferTo)
+}
+
+// deleteAccount deletes user, first giving their links to user.TransferTo if
+// set. Should that user have since been deleted themselves, the links are
+// deleted along with the account as if no transfer had been asked for.
+func (s *server) deleteAccount(user database.User) error {
+	if user.TransferTo != "" {
+		_, err := s.store.GetUser(user.TransferTo)
+		if err != nil {
+			fmt.Printf("deleting links of %s as %s no longer exists\n", user.Username, user.TransferTo)
+		} else {
+			err = s.store.TransferURLs(user.Username, user.TransferTo)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return s.store.DeleteUser(user.Username)
+}
+
+// cancelDeletion stops a scheduled deletion of username's account, reporting
+// whether there was one.
+func (s *server) cancelDeletion(username string) (bool, error) {
+	user, err := s.store.GetUser(username)
+	if err != nil || user.DeleteAt.IsZero() {
+		return false, err
+	}
+	return true, s.store.CancelUserDeletion(username)
+}
+
+// formatGrace describes a grace period in whole days where it is at least a
+// day long.
+func formatGrace(grace time.Duration) string {
+	days := int(grace / (24 * time.Hour))
+	switch {
+	case days == 1:
+		return "1 day"
+	case days > 1:
+		return fmt.Sprintf("%d days", days)
+	default:
+		return grace.String()
+	}
+}
+
+// deleteDueUsers periodically deletes the accounts whose grace period is up
+// until stop is closed.
+func (s *server) deleteDueUsers(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			users, err := s.store.GetUsersDueForDeletion(time.Now())
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			deleted := 0
+			for _, user := range users {
+				err = s.deleteAccount(user)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				deleted++
+			}
+			if deleted > 0 {
+				fmt.Printf("deleted %d accounts\n", deleted)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
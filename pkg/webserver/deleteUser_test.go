@@ -0,0 +1,42 @@
+package webserver_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+func TestPendingDeletionSignsOutEverywhere(t *testing.T) {
+	config := testConfig
+	config.DeletionGrace = time.Hour
+	store := database.NewMemoryStore()
+	server := newTestServerWith(t, config, store)
+
+	laptop := newClient(t)
+	post(t, laptop, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+	phone := newClient(t)
+	post(t, phone, server.URL+"/login", url.Values{"username": {"bob"}, "password": {"pw"}})
+	if res := get(t, phone, server.URL+"/profile"); res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d before deleting", res.StatusCode)
+	}
+
+	post(t, laptop, server.URL+"/deleteUser", url.Values{"password": {"pw"}})
+	if res := get(t, phone, server.URL+"/profile"); res.StatusCode == http.StatusOK {
+		t.Fatal("another session kept working while the account waits to be deleted")
+	}
+	user, err := store.GetUser("bob")
+	if err != nil || user.DeleteAt.IsZero() {
+		t.Fatalf("got %+v, %v", user, err)
+	}
+
+	post(t, phone, server.URL+"/login", url.Values{"username": {"bob"}, "password": {"pw"}})
+	user, err = store.GetUser("bob")
+	if err != nil || !user.DeleteAt.IsZero() {
+		t.Fatal("logging in did not cancel the deletion")
+	}
+	if res := get(t, phone, server.URL+"/profile"); res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d after logging in again", res.StatusCode)
+	}
+}
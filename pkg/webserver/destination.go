@@ -0,0 +1,208 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const destinationLookupTimeout = 2 * time.Second
+
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+// DestinationConfig limits where short links may point. DenyDomains blocks
+// each listed domain along with all of its subdomains.
+type DestinationConfig struct {
+	AllowedSchemes []string
+	AllowPrivate   bool
+	DenyDomains    []string
+}
+
+type destinationPolicy struct {
+	schemes      map[string]bool
+	allowPrivate bool
+	denyDomains  []string
+}
+
+func newDestinationPolicy(config DestinationConfig) *destinationPolicy {
+	if len(config.AllowedSchemes) == 0 {
+		config.AllowedSchemes = DefaultAllowedSchemes
+	}
+
+	schemes := make(map[string]bool)
+	for _, scheme := range config.AllowedSchemes {
+		schemes[strings.ToLower(scheme)] = true
+	}
+	var denyDomains []string
+	for _, domain := range config.DenyDomains {
+		denyDomains = append(denyDomains, strings.Trim(strings.ToLower(domain), "."))
+	}
+
+	return &destinationPolicy{
+		schemes:      schemes,
+		allowPrivate: config.AllowPrivate,
+		denyDomains:  denyDomains,
+	}
+}
+
+// check validates a destination before it is stored. Host names are resolved
+// so that names pointing at private addresses are caught too; a name that
+// cannot be resolved is let through, as the shortener may not share the
+// visitor's DNS.
+func (p *destinationPolicy) check(destination string) error {
+	u, err := p.checkStatic(destination)
+	if err != nil {
+		return err
+	}
+	if p.allowPrivate {
+		return nil
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = parseNumericIPv4(host)
+	}
+	if ip != nil {
+		if isPrivate(ip) {
+			return fmt.Errorf("URLs may not point at private or loopback addresses")
+		}
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("URLs may not point at private or loopback addresses")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), destinationLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if isPrivate(addr.IP) {
+			return fmt.Errorf("URLs may not point at private or loopback addresses")
+		}
+	}
+	return nil
+}
+
+// checkStatic applies the scheme allowlist and domain denylist, which need no
+// network access and so are also cheap enough to recheck on every redirect.
+func (p *destinationPolicy) checkStatic(destination string) (*url.URL, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("please enter a valid url")
+	}
+	if !p.schemes[strings.ToLower(u.Scheme)] {
+		return nil, fmt.Errorf("URLs must use one of the schemes: %s", strings.Join(p.allowedSchemes(), ", "))
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("please enter a valid url")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, domain := range p.denyDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil, fmt.Errorf("URLs to %s are not allowed", domain)
+		}
+	}
+	return u, nil
+}
+
+func (p *destinationPolicy) allowedSchemes() []string {
+	var schemes []string
+	for scheme := range p.schemes {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// parseNumericIPv4 reads the shorthand IPv4 forms that inet_aton and browsers
+// accept but net.ParseIP does not, such as 2130706433, 0x7f.1 or 0177.0.0.1.
+// Each of one to four parts may be decimal, octal with a leading 0 or hex
+// with 0x, and the last part fills all the bytes left over. It returns nil
+// if host is not such an address.
+func parseNumericIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	var addr uint64
+	for i, part := range parts {
+		n, ok := parseIPv4Part(part)
+		if !ok {
+			return nil
+		}
+		bits := uint(8)
+		if i == len(parts)-1 {
+			bits = uint(8 * (4 - i))
+		}
+		if n >= 1<<bits {
+			return nil
+		}
+		addr = addr<<bits | n
+	}
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x"):
+		base, part = 16, part[2:]
+		if part == "" {
+			return 0, true
+		}
+	case len(part) > 1 && part[0] == '0':
+		base, part = 8, part[1:]
+	}
+	for _, c := range part {
+		if !strings.ContainsRune("0123456789abcdef"[:base], c) {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseUint(part, base, 32)
+	return n, err == nil
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
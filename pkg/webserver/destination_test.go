@@ -0,0 +1,33 @@
+package webserver_test
+
+import (
+	"net/url"
+	"testing"
+	"urlShortener/pkg/database"
+)
+
+func TestPrivateDestinationsAreRejected(t *testing.T) {
+	config := testConfig
+	config.Destinations.AllowPrivate = false
+	server := newTestServerWith(t, config, database.NewMemoryStore())
+	client := newClient(t)
+
+	for _, destination := range []string{
+		"http://127.0.0.1/",
+		"http://2130706433/",
+		"http://0x7f.1/",
+		"http://0177.0.0.1/",
+		"http://127.1/",
+		"http://0x7F000001./",
+		"http://LOCALHOST./",
+	} {
+		res := post(t, client, server.URL+"/", url.Values{"url": {destination}})
+		for _, cookie := range res.Cookies() {
+			if cookie.Name == "created" {
+				t.Errorf("%s was shortened to %s", destination, cookie.Value)
+			}
+		}
+	}
+
+	shorten(t, client, server.URL, url.Values{"url": {"http://0x08080808/"}})
+}
@@ -0,0 +1,53 @@
+package webserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+type expiredInformation struct {
+	Short      string
+	ExpiresAt  time.Time
+	MaxClicks  int64
+	ClicksUsed bool
+}
+
+const expiredTemplateLocation = "templates/expired.html"
+
+var expiredTemplate = template.Must(template.ParseFS(templates, expiredTemplateLocation))
+
+func showExpiredPage(res http.ResponseWriter, req *http.Request, record database.Record) {
+	info := &expiredInformation{
+		Short:      record.Short,
+		ExpiresAt:  record.ExpiresAt,
+		MaxClicks:  record.MaxClicks,
+		ClicksUsed: record.MaxClicks > 0 && record.Clicks >= record.MaxClicks,
+	}
+
+	res.WriteHeader(http.StatusGone)
+	expiredTemplate.Execute(res, info)
+}
+
+// sweepExpired periodically removes expired links from the store until stop
+// is closed, archiving them instead when archive is set.
+func (s *server) sweepExpired(interval time.Duration, archive bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			count, err := s.store.SweepExpired(time.Now(), archive)
+			if err != nil {
+				fmt.Println(err)
+			} else if count > 0 {
+				fmt.Printf("swept %d expired links\n", count)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
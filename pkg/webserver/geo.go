@@ -0,0 +1,20 @@
+package webserver
+
+import "net"
+
+// locate returns the ISO country code and English city name for ip, or empty
+// strings when they cannot be found.
+func (s *server) locate(ip string) (country, city string) {
+	if s.geoDB == nil {
+		return "", ""
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", ""
+	}
+	record, err := s.geoDB.City(parsed)
+	if err != nil {
+		return "", ""
+	}
+	return record.Country.IsoCode, record.City.Names["en"]
+}
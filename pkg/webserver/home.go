@@ -4,9 +4,8 @@ import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"html/template"
-	"math/rand"
 	"net/http"
-	"net/url"
+	"strconv"
 	"time"
 	"urlShortener/pkg/database"
 )
@@ -18,23 +17,28 @@ type homePageInformation struct {
 	Shortened        string
 	LoggedIn         bool
 	LoggedInAs       string
+	RedirectStatuses []int
+	DefaultRedirect  int
+	Campaigns        []database.Campaign
 }
 
-const homeTemplateLocation = "pkg/webserver/templates/home.html"
-
-var homeTemplate = template.Must(template.ParseFiles(homeTemplateLocation))
+const (
+	homeTemplateLocation = "templates/home.html"
+	expiryFormLayout     = "2006-01-02T15:04"
+)
 
-func init() {
-	rand.Seed(int64(time.Now().Nanosecond()))
-}
+var homeTemplate = template.Must(template.ParseFS(templates, homeTemplateLocation))
 
-func showHomePage(res http.ResponseWriter, req *http.Request) {
+func (s *server) showHomePage(res http.ResponseWriter, req *http.Request) {
 	info := new(homePageInformation)
+	info.RedirectStatuses = redirectStatuses
+	info.DefaultRedirect = s.config.RedirectStatus
 
-	user, err := verifyUsernameCookie(res, req)
+	user, err := s.verifyUsernameCookie(res, req)
 	if err == nil {
 		info.LoggedIn = true
 		info.LoggedInAs = user
+		info.Campaigns, _ = s.store.GetCampaigns(user)
 	}
 
 	errorCookie, err := req.Cookie("error")
@@ -64,7 +68,7 @@ func showHomePage(res http.ResponseWriter, req *http.Request) {
 	homeTemplate.Execute(res, info)
 }
 
-func handleURLUpload(res http.ResponseWriter, req *http.Request) {
+func (s *server) handleURLUpload(res http.ResponseWriter, req *http.Request) {
 	// TODO: nice error handling
 	err := req.ParseForm()
 	if err != nil {
@@ -90,13 +94,53 @@ func handleURLUpload(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	userURL := userURLs[0]
+	link := linkRequest{Long: userURLs[0]}
+
+	urlRequest := req.Form["urlRequest"]
+	if len(urlRequest) != 0 {
+		link.Requested = urlRequest[0]
+	}
+
+	expiresAt := req.Form.Get("expiresAt")
+	if expiresAt != "" {
+		link.ExpiresAt, err = time.ParseInLocation(expiryFormLayout, expiresAt, time.Local)
+		if err != nil {
+			http.SetCookie(res, &http.Cookie{
+				Name:    "error",
+				Value:   "please enter a valid expiry date",
+				Expires: time.Now().Add(time.Minute),
+				Path:    "/",
+			})
+			http.Redirect(res, req, "/", http.StatusSeeOther)
+			return
+		}
+	}
 
-	_, err = url.ParseRequestURI(userURL)
+	maxClicks := req.Form.Get("maxClicks")
+	if maxClicks != "" {
+		link.MaxClicks, err = strconv.ParseInt(maxClicks, 10, 64)
+		if err != nil || link.MaxClicks < 0 {
+			http.SetCookie(res, &http.Cookie{
+				Name:    "error",
+				Value:   "please enter a valid maximum number of clicks",
+				Expires: time.Now().Add(time.Minute),
+				Path:    "/",
+			})
+			http.Redirect(res, req, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	link.Password = req.Form.Get("password")
+	link.Passthrough = req.Form.Get("passthrough") != ""
+	link.UTM = utmFromForm(req.Form)
+	link.Campaign = req.Form.Get("campaign")
+
+	link.RedirectStatus, err = parseRedirectStatus(req.Form.Get("redirectType"))
 	if err != nil {
 		http.SetCookie(res, &http.Cookie{
 			Name:    "error",
-			Value:   "please enter a valid url",
+			Value:   err.Error(),
 			Expires: time.Now().Add(time.Minute),
 			Path:    "/",
 		})
@@ -104,16 +148,8 @@ func handleURLUpload(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var shortened string
-
-	urlRequest := req.Form["urlRequest"]
-	if len(urlRequest) == 0 || len(urlRequest[0]) == 0 {
-		shortened = randomChars()
-	} else {
-		shortened = urlRequest[0]
-	}
-
-	err = database.AddURL(userURL, shortened)
+	user, err := s.verifyUsernameCookie(res, req)
+	shortened, err := s.createLink(link, user, err == nil)
 	if err != nil {
 		http.SetCookie(res, &http.Cookie{
 			Name:    "error",
@@ -131,24 +167,10 @@ func handleURLUpload(res http.ResponseWriter, req *http.Request) {
 		Path:    "/",
 	})
 
-	user, err := verifyUsernameCookie(res, req)
-	if err == nil {
-		database.Link(user, shortened)
-	}
-
 	http.Redirect(res, req, "/", http.StatusSeeOther)
 }
 
-func randomChars() string {
-	chars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	res := make([]byte, 8)
-	for i := 0; i < 8; i++ {
-		res[i] = chars[rand.Intn(len(chars))]
-	}
-	return string(res)
-}
-
-func getUsernameCookie(res http.ResponseWriter, req *http.Request) (string, error) {
+func (s *server) getUsernameCookie(res http.ResponseWriter, req *http.Request) (string, error) {
 	loginCookie, err := req.Cookie("login")
 	if err != nil {
 		return "", err
@@ -159,7 +181,7 @@ func getUsernameCookie(res http.ResponseWriter, req *http.Request) (string, erro
 		if !ok {
 			return nil, fmt.Errorf("method not valid")
 		}
-		return jwtSecret, nil
+		return s.jwtSecret, nil
 	})
 	if err != nil {
 		return "", err
@@ -168,16 +190,26 @@ func getUsernameCookie(res http.ResponseWriter, req *http.Request) (string, erro
 	if !ok || !token.Valid {
 		return "", fmt.Errorf("user could not be verified")
 	}
-	return claims["username"].(string), nil
+	username, ok := claims["username"].(string)
+	if !ok {
+		return "", fmt.Errorf("user could not be verified")
+	}
+	return username, nil
 }
 
-func verifyUsernameCookie(res http.ResponseWriter, req *http.Request) (string, error) {
-	username, err := getUsernameCookie(res, req)
+// verifyUsernameCookie returns the user the login cookie belongs to. Accounts
+// waiting to be deleted are signed out everywhere, as only logging in again
+// with the password may cancel the deletion.
+func (s *server) verifyUsernameCookie(res http.ResponseWriter, req *http.Request) (string, error) {
+	username, err := s.getUsernameCookie(res, req)
 	if err != nil {
 		return "", err
 	}
 
-	_, err = database.GetUser(username)
+	user, err := s.store.GetUser(username)
+	if err == nil && !user.DeleteAt.IsZero() {
+		err = fmt.Errorf("account is waiting to be deleted")
+	}
 	if err != nil {
 		http.SetCookie(res, &http.Cookie{
 			Name:    "login",
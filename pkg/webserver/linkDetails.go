@@ -0,0 +1,527 @@
+package webserver
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+type chartBar struct {
+	Label   string
+	Count   int
+	Percent int
+}
+
+type linkDetailsInformation struct {
+	ErrorHappened    bool
+	Error            string
+	LoggedInAs       string
+	Record           database.Record
+	From             string
+	To               string
+	By               string
+	Total            int
+	Unique           int
+	Series           []chartBar
+	Referrers        []chartBar
+	Browsers         []chartBar
+	OperatingSystems []chartBar
+	Countries        []chartBar
+	Variants         []variantSummary
+	RuleKinds        []string
+	RedirectStatuses []int
+	DefaultRedirect  int
+	Campaigns        []database.Campaign
+	Versions         []database.Version
+}
+
+// variantSummary compares one A/B variant with the others over the chosen
+// range. Share is the variant's percentage of the total weight and Percent
+// its percentage of the split clicks. Variants that have since been deleted
+// but still have clicks in range are listed with an Index of -1.
+type variantSummary struct {
+	Index       int
+	Name        string
+	Destination string
+	Weight      int
+	Share       int
+	Clicks      int
+	Unique      int
+	Percent     int
+}
+
+const (
+	linkDetailsTemplateLocation = "templates/linkDetails.html"
+	detailsDateLayout           = "2006-01-02"
+	detailsDefaultDays          = 7
+	detailsMaxBars              = 400
+	detailsTopEntries           = 10
+)
+
+var linkDetailsTemplate = template.Must(template.New("linkDetails.html").Funcs(template.FuncMap{
+	"breakdown": func(title string, bars []chartBar) interface{} {
+		return struct {
+			Title string
+			Bars  []chartBar
+		}{title, bars}
+	},
+}).ParseFS(templates, linkDetailsTemplateLocation))
+
+// detailsGroupings are the ways clicks can be bucketed for the chart, keyed by
+// the "by" query parameter.
+var detailsGroupings = map[string]struct {
+	start func(time.Time) time.Time
+	step  func(time.Time) time.Time
+	label string
+}{
+	"hour": {
+		start: func(t time.Time) time.Time { return t.Truncate(time.Hour) },
+		step:  func(t time.Time) time.Time { return t.Add(time.Hour) },
+		label: "2 Jan 15:00",
+	},
+	"day": {
+		start: func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()) },
+		step:  func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+		label: "Mon 2 Jan",
+	},
+	"week": {
+		start: func(t time.Time) time.Time {
+			day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		},
+		step:  func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+		label: "w/c 2 Jan 2006",
+	},
+}
+
+func (s *server) showLinkDetailsPage(res http.ResponseWriter, req *http.Request) {
+	info := new(linkDetailsInformation)
+
+	errorCookie, err := req.Cookie("error")
+	if err == nil {
+		info.ErrorHappened = true
+		info.Error = errorCookie.Value
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   "",
+			Expires: time.Now(),
+			Path:    routeMain,
+		})
+	}
+
+	shortened := mux.Vars(req)["key"]
+	user, _ := s.verifyUsernameCookie(res, req)
+	info.LoggedInAs = user
+	info.RuleKinds = ruleKinds
+	info.RedirectStatuses = redirectStatuses
+	info.DefaultRedirect = s.config.RedirectStatus
+	info.Campaigns, _ = s.store.GetCampaigns(user)
+	if !s.store.VerifyOwns(user, shortened) {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   "URL not owned by you",
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+		http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+		return
+	}
+
+	record, err := s.store.GetUrl(shortened)
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = err.Error()
+		linkDetailsTemplate.Execute(res, info)
+		return
+	}
+	info.Record = record
+
+	versions, err := s.store.GetVersions(shortened)
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = err.Error()
+	}
+	info.Versions = versions
+
+	from, to, by, err := parseDetailsRange(req.URL.Query())
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = err.Error()
+		from, to, by, _ = parseDetailsRange(url.Values{})
+	}
+	info.From = from.Format(detailsDateLayout)
+	info.To = to.AddDate(0, 0, -1).Format(detailsDateLayout)
+	info.By = by
+
+	clicks, err := s.store.GetClicks(shortened, from, to)
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = err.Error()
+	}
+
+	info.Total = len(clicks)
+	visitors := make(map[string]bool)
+	referrers := make(map[string]int)
+	browsers := make(map[string]int)
+	operatingSystems := make(map[string]int)
+	countries := make(map[string]int)
+	for _, click := range clicks {
+		visitors[click.IPHash] = true
+		referrers[refererHost(click.Referer)]++
+		browsers[browserName(click.UserAgent)]++
+		operatingSystems[osName(click.UserAgent)]++
+		countries[countryName(click.Country)]++
+	}
+	info.Unique = len(visitors)
+	info.Series = clickSeries(clicks, from, to, by)
+	info.Referrers = topBars(referrers, info.Total)
+	info.Browsers = topBars(browsers, info.Total)
+	info.OperatingSystems = topBars(operatingSystems, info.Total)
+	info.Countries = topBars(countries, info.Total)
+	info.Variants = summariseVariants(record.Variants, clicks)
+
+	linkDetailsTemplate.Execute(res, info)
+}
+
+// handleDestinationUpdate points a link somewhere else, keeping its code.
+func (s *server) handleDestinationUpdate(res http.ResponseWriter, req *http.Request) {
+	user, _ := s.verifyUsernameCookie(res, req)
+	s.updateLink(res, req, func(record *database.Record, form url.Values) error {
+		return s.editDestination(record, form.Get("url"), user)
+	})
+}
+
+// handleRulesUpdate adds, removes or reorders one of a link's targeting
+// rules, depending on the action sent with the form.
+func (s *server) handleRulesUpdate(res http.ResponseWriter, req *http.Request) {
+	s.updateLink(res, req, func(record *database.Record, form url.Values) (err error) {
+		record.Rules, err = s.updateRules(record.Rules, form)
+		return err
+	})
+}
+
+// handleVariantsUpdate changes a link's A/B split.
+func (s *server) handleVariantsUpdate(res http.ResponseWriter, req *http.Request) {
+	s.updateLink(res, req, func(record *database.Record, form url.Values) (err error) {
+		record.Variants, err = s.updateVariants(record.Variants, form)
+		return err
+	})
+}
+
+// handlePasswordUpdate sets or removes the password protecting a link.
+func (s *server) handlePasswordUpdate(res http.ResponseWriter, req *http.Request) {
+	s.updateLink(res, req, func(record *database.Record, form url.Values) error {
+		if form.Get("action") == "remove" {
+			return record.SetPassword("")
+		}
+		password := form.Get("password")
+		if password == "" {
+			return fmt.Errorf("please enter a password")
+		}
+		return record.SetPassword(password)
+	})
+}
+
+// handleInterstitialUpdate turns the forced preview of a link on or off.
+func (s *server) handleInterstitialUpdate(res http.ResponseWriter, req *http.Request) {
+	s.updateLink(res, req, func(record *database.Record, form url.Values) error {
+		record.Interstitial = form.Get("action") == "on"
+		return nil
+	})
+}
+
+// handleRedirectUpdate changes the status a link redirects with.
+func (s *server) handleRedirectUpdate(res http.ResponseWriter, req *http.Request) {
+	s.updateLink(res, req, func(record *database.Record, form url.Values) (err error) {
+		record.RedirectStatus, err = parseRedirectStatus(form.Get("redirectType"))
+		return err
+	})
+}
+
+// handlePassthroughUpdate turns forwarding of the extra path and query on or
+// off for a link.
+func (s *server) handlePassthroughUpdate(res http.ResponseWriter, req *http.Request) {
+	s.updateLink(res, req, func(record *database.Record, form url.Values) error {
+		record.Passthrough = form.Get("action") == "on"
+		return nil
+	})
+}
+
+// handleUTMUpdate changes the UTM parameters added to a link's destination,
+// optionally filling them in from one of the user's campaigns.
+func (s *server) handleUTMUpdate(res http.ResponseWriter, req *http.Request) {
+	user, _ := s.verifyUsernameCookie(res, req)
+	s.updateLink(res, req, func(record *database.Record, form url.Values) error {
+		utm, err := s.applyCampaign(utmFromForm(form), user, form.Get("campaign"))
+		if err != nil {
+			return err
+		}
+		record.UTM, err = checkUTM(utm)
+		return err
+	})
+}
+
+// handleRollback restores a link to one of its earlier versions. The version
+// it replaces is kept too, so a rollback can itself be undone.
+func (s *server) handleRollback(res http.ResponseWriter, req *http.Request) {
+	s.updateLink(res, req, func(record *database.Record, form url.Values) error {
+		number, err := strconv.Atoi(form.Get("version"))
+		if err != nil {
+			return fmt.Errorf("version not found")
+		}
+		versions, err := s.store.GetVersions(record.Short)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			if version.Number != number {
+				continue
+			}
+			err = s.destinations.check(version.Record.Long)
+			if err != nil {
+				return err
+			}
+			*record = version.Record
+			return nil
+		}
+		return fmt.Errorf("version not found")
+	})
+}
+
+// updateLink applies a change posted from the details page to the link the
+// user owns, then sends them back to the page. The user is noted as the last
+// editor of the link.
+func (s *server) updateLink(res http.ResponseWriter, req *http.Request, update func(record *database.Record, form url.Values) error) {
+	req.ParseForm()
+	shortened := mux.Vars(req)["key"]
+	detailsPage := linkDetailsPath(shortened)
+
+	user, _ := s.verifyUsernameCookie(res, req)
+	if !s.store.VerifyOwns(user, shortened) {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   "URL not owned by you",
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+		http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+		return
+	}
+
+	record, err := s.store.GetUrl(shortened)
+	if err == nil {
+		err = update(&record, req.Form)
+	}
+	if err == nil {
+		record.EditedAt = time.Now()
+		record.EditedBy = user
+		err = s.store.UpdateURL(record)
+	}
+	if err != nil {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   err.Error(),
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+	}
+	http.Redirect(res, req, detailsPage, http.StatusSeeOther)
+}
+
+func (s *server) updateRules(rules []database.TargetRule, form url.Values) ([]database.TargetRule, error) {
+	rules = append([]database.TargetRule(nil), rules...)
+	action := form.Get("action")
+	if action == "add" {
+		rule, err := s.newTargetRule(form.Get("kind"), form.Get("value"), form.Get("destination"))
+		if err != nil {
+			return nil, err
+		}
+		return append(rules, rule), nil
+	}
+
+	i, err := strconv.Atoi(form.Get("index"))
+	if err != nil || i < 0 || i >= len(rules) {
+		return nil, fmt.Errorf("rule not found")
+	}
+	switch action {
+	case "delete":
+		return append(rules[:i:i], rules[i+1:]...), nil
+	case "up":
+		if i > 0 {
+			rules[i-1], rules[i] = rules[i], rules[i-1]
+		}
+		return rules, nil
+	case "down":
+		if i < len(rules)-1 {
+			rules[i], rules[i+1] = rules[i+1], rules[i]
+		}
+		return rules, nil
+	default:
+		return nil, fmt.Errorf("unknown action")
+	}
+}
+
+func linkDetailsPath(shortened string) string {
+	return strings.Replace(routeLinkDetail, "{key}", url.PathEscape(shortened), 1)
+}
+
+// parseDetailsRange reads the inclusive from and to dates and the grouping
+// from the query string, defaulting to the last week by day. The returned to
+// is exclusive.
+func parseDetailsRange(query url.Values) (time.Time, time.Time, string, error) {
+	today := detailsGroupings["day"].start(time.Now())
+	from := today.AddDate(0, 0, 1-detailsDefaultDays)
+	to := today.AddDate(0, 0, 1)
+	by := "day"
+
+	var err error
+	if value := query.Get("from"); value != "" {
+		from, err = time.ParseInLocation(detailsDateLayout, value, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("please enter a valid start date")
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		to, err = time.ParseInLocation(detailsDateLayout, value, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("please enter a valid end date")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("start date must not be after end date")
+	}
+	if value := query.Get("by"); value != "" {
+		_, ok := detailsGroupings[value]
+		if !ok {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("clicks can only be grouped by hour, day or week")
+		}
+		by = value
+	}
+	if to.Sub(from)/time.Hour > detailsMaxBars && by == "hour" {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("range is too long to group by hour")
+	}
+	return from, to, by, nil
+}
+
+// clickSeries counts clicks into consecutive buckets covering [from, to),
+// including the empty ones so the chart has no gaps.
+func clickSeries(clicks []database.Click, from, to time.Time, by string) []chartBar {
+	grouping := detailsGroupings[by]
+
+	var bars []chartBar
+	index := make(map[time.Time]int)
+	for t := grouping.start(from); t.Before(to) && len(bars) < detailsMaxBars; t = grouping.step(t) {
+		index[t] = len(bars)
+		bars = append(bars, chartBar{Label: t.Format(grouping.label)})
+	}
+
+	highest := 0
+	for _, click := range clicks {
+		i, ok := index[grouping.start(click.Time.In(time.Local))]
+		if !ok {
+			continue
+		}
+		bars[i].Count++
+		if bars[i].Count > highest {
+			highest = bars[i].Count
+		}
+	}
+	for i := range bars {
+		if highest > 0 {
+			bars[i].Percent = bars[i].Count * 100 / highest
+		}
+	}
+	return bars
+}
+
+func summariseVariants(variants []database.Variant, clicks []database.Click) []variantSummary {
+	var summaries []variantSummary
+	index := make(map[string]int)
+	totalWeight := 0
+	for i, variant := range variants {
+		index[variant.Name] = len(summaries)
+		summaries = append(summaries, variantSummary{
+			Index:       i,
+			Name:        variant.Name,
+			Destination: variant.Destination,
+			Weight:      variant.Weight,
+		})
+		totalWeight += variant.Weight
+	}
+
+	visitors := make(map[string]map[string]bool)
+	split := 0
+	for _, click := range clicks {
+		if click.Variant == "" {
+			continue
+		}
+		i, ok := index[click.Variant]
+		if !ok {
+			i = len(summaries)
+			index[click.Variant] = i
+			summaries = append(summaries, variantSummary{Index: -1, Name: click.Variant})
+		}
+		if visitors[click.Variant] == nil {
+			visitors[click.Variant] = make(map[string]bool)
+		}
+		summaries[i].Clicks++
+		visitors[click.Variant][click.IPHash] = true
+		split++
+	}
+
+	for i := range summaries {
+		summaries[i].Unique = len(visitors[summaries[i].Name])
+		if totalWeight > 0 {
+			summaries[i].Share = summaries[i].Weight * 100 / totalWeight
+		}
+		if split > 0 {
+			summaries[i].Percent = summaries[i].Clicks * 100 / split
+		}
+	}
+	return summaries
+}
+
+// topBars turns counts into bars sorted by count, keeping the most common
+// entries, with each bar's share of total.
+func topBars(counts map[string]int, total int) []chartBar {
+	var bars []chartBar
+	for label, count := range counts {
+		bars = append(bars, chartBar{Label: label, Count: count, Percent: count * 100 / total})
+	}
+	sort.Slice(bars, func(i, j int) bool {
+		if bars[i].Count != bars[j].Count {
+			return bars[i].Count > bars[j].Count
+		}
+		return bars[i].Label < bars[j].Label
+	})
+	if len(bars) > detailsTopEntries {
+		bars = bars[:detailsTopEntries]
+	}
+	return bars
+}
+
+func refererHost(referer string) string {
+	if referer == "" {
+		return "Direct"
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return "Other"
+	}
+	return u.Host
+}
+
+func countryName(code string) string {
+	if code == "" {
+		return "Unknown"
+	}
+	return code
+}
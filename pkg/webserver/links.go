@@ -0,0 +1,169 @@
+package webserver
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+// linkRequest is a URL to shorten along with its optional settings, as sent
+// from the home page form or the API. Password is in plain text. Campaign
+// names one of the user's campaigns to fill in whatever UTM leaves empty.
+type linkRequest struct {
+	Long           string
+	Requested      string
+	ExpiresAt      time.Time
+	MaxClicks      int64
+	Password       string
+	RedirectStatus int
+	Passthrough    bool
+	UTM            database.UTM
+	Campaign       string
+}
+
+// createLink validates and stores a new link, making user its owner when
+// loggedIn, and returns its short code. Errors from it and from the checks it
+// shares with the link settings pages, such as validating short codes,
+// destinations, rules and variants, are worded to be shown to the user as is.
+func (s *server) createLink(link linkRequest, user string, loggedIn bool) (string, error) {
+	_, err := url.ParseRequestURI(link.Long)
+	if err != nil {
+		return "", fmt.Errorf("please enter a valid url")
+	}
+
+	err = s.destinations.check(link.Long)
+	if err != nil {
+		return "", err
+	}
+
+	requested := link.Requested != ""
+	if requested {
+		err = s.customCodes.validate(link.Requested)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !link.ExpiresAt.IsZero() && !link.ExpiresAt.After(time.Now()) {
+		return "", fmt.Errorf("expiry date must be in the future")
+	}
+	if link.MaxClicks < 0 {
+		return "", fmt.Errorf("maximum clicks cannot be negative")
+	}
+	if link.RedirectStatus != 0 && !validRedirectStatus(link.RedirectStatus) {
+		return "", fmt.Errorf("redirect type must be one of 301, 302, 307 or 308")
+	}
+	if link.Campaign != "" && !loggedIn {
+		return "", fmt.Errorf("you must be logged in to use a campaign")
+	}
+	link.UTM, err = s.applyCampaign(link.UTM, user, link.Campaign)
+	if err != nil {
+		return "", err
+	}
+	link.UTM, err = checkUTM(link.UTM)
+	if err != nil {
+		return "", err
+	}
+
+	record := database.Record{
+		Short:          link.Requested,
+		Long:           link.Long,
+		ExpiresAt:      link.ExpiresAt,
+		MaxClicks:      link.MaxClicks,
+		RedirectStatus: link.RedirectStatus,
+		Passthrough:    link.Passthrough,
+		UTM:            link.UTM,
+	}
+	if s.config.Dedupe {
+		record.Normalised = normaliseURL(link.Long)
+	}
+	err = record.SetPassword(link.Password)
+	if err != nil {
+		return "", err
+	}
+
+	if s.config.Dedupe && loggedIn && !requested {
+		existing, err := s.store.FindURLOf(user, record.Normalised)
+		if err == nil && reusable(existing, record, time.Now()) {
+			return existing.Short, nil
+		}
+	}
+
+	var shortened string
+	created := true
+
+	if !requested {
+		owner := ""
+		if loggedIn {
+			owner = user
+		}
+		shortened, created, err = s.addGeneratedURL(record, owner)
+	} else {
+		shortened = link.Requested
+		err = s.store.AddURL(record)
+	}
+	if err == database.ErrURLTaken {
+		return "", fmt.Errorf("That shortened URL is already taken")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if loggedIn && created {
+		s.store.Link(user, shortened)
+	}
+
+	return shortened, nil
+}
+
+// reusable reports whether existing can be handed out in place of creating
+// record, which it can only while it still works and has the same settings.
+// Password protected links are never shared, as their hashes cannot be
+// compared and a protected link must not be swapped for an open one.
+func reusable(existing, record database.Record, now time.Time) bool {
+	return !existing.Expired(now) &&
+		existing.Password == "" &&
+		record.Password == "" &&
+		existing.ExpiresAt.Equal(record.ExpiresAt) &&
+		existing.MaxClicks == record.MaxClicks &&
+		existing.RedirectStatus == record.RedirectStatus &&
+		existing.Passthrough == record.Passthrough &&
+		existing.UTM == record.UTM &&
+		!existing.Interstitial &&
+		len(existing.Rules) == 0 &&
+		len(existing.Variants) == 0
+}
+
+// destinationKey identifies where record goes when looking for a duplicate:
+// its normalised form when dedupe is on, or Long exactly as entered otherwise.
+func destinationKey(record database.Record) string {
+	if record.Normalised != "" {
+		return record.Normalised
+	}
+	return record.Long
+}
+
+// editDestination points record at long instead, noting user as the editor.
+func (s *server) editDestination(record *database.Record, long, user string) error {
+	_, err := url.ParseRequestURI(long)
+	if err != nil {
+		return fmt.Errorf("please enter a valid url")
+	}
+	err = s.destinations.check(long)
+	if err != nil {
+		return err
+	}
+	if long == record.Long {
+		return fmt.Errorf("the link already points there")
+	}
+
+	record.Long = long
+	record.Normalised = ""
+	if s.config.Dedupe {
+		record.Normalised = normaliseURL(long)
+	}
+	record.EditedAt = time.Now()
+	record.EditedBy = user
+	return nil
+}
@@ -0,0 +1,156 @@
+package webserver_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"urlShortener/pkg/database"
+	"urlShortener/pkg/webserver"
+)
+
+// shorten shortens form's url through the home page and returns the code
+// handed back, failing the test if there is none.
+func shorten(t *testing.T, client *http.Client, server string, form url.Values) string {
+	t.Helper()
+	res := post(t, client, server+"/", form)
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "created" {
+			return cookie.Value
+		}
+	}
+	t.Fatalf("no link created for %v", form)
+	return ""
+}
+
+func TestDedupeOnlyReusesMatchingLinks(t *testing.T) {
+	config := testConfig
+	config.Dedupe = true
+	store := database.NewMemoryStore()
+	server := newTestServerWith(t, config, store)
+	client := newClient(t)
+	post(t, client, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+
+	plain := shorten(t, client, server.URL, url.Values{"url": {"https://example.com/doc"}})
+	if again := shorten(t, client, server.URL, url.Values{"url": {"https://example.com/doc"}}); again != plain {
+		t.Fatalf("got %s for the same url, want %s", again, plain)
+	}
+
+	limited := shorten(t, client, server.URL, url.Values{"url": {"https://example.com/doc"}, "maxClicks": {"1"}})
+	if limited == plain {
+		t.Fatal("a click limited link reused an unlimited one")
+	}
+	record, err := store.GetUrl(limited)
+	if err != nil || record.MaxClicks != 1 {
+		t.Fatalf("got %+v, %v", record, err)
+	}
+
+	get(t, client, server.URL+"/u/"+limited)
+	if again := shorten(t, client, server.URL, url.Values{"url": {"https://example.com/doc"}, "maxClicks": {"1"}}); again == limited {
+		t.Fatal("a used up link was reused")
+	}
+}
+
+func TestDedupeKeepsDestinationAsEntered(t *testing.T) {
+	config := testConfig
+	config.Dedupe = true
+	server := newTestServerWith(t, config, database.NewMemoryStore())
+	client := newClient(t)
+	post(t, client, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+
+	entered := "https://Example.com/dir/?b=2&a=1"
+	short := shorten(t, client, server.URL, url.Values{"url": {entered}})
+	res := get(t, client, server.URL+"/u/"+short)
+	if location := res.Header.Get("Location"); location != entered {
+		t.Fatalf("redirected to %q, want %q", location, entered)
+	}
+	if again := shorten(t, client, server.URL, url.Values{"url": {"https://example.com/dir?a=1&b=2"}}); again != short {
+		t.Fatalf("got %s for the same url typed differently, want %s", again, short)
+	}
+}
+
+func TestHashCodesAreNotSharedAcrossSettings(t *testing.T) {
+	config := testConfig
+	config.ShortCodes.Strategy = webserver.StrategyHash
+	server := newTestServerWith(t, config, database.NewMemoryStore())
+
+	plain := shorten(t, newClient(t), server.URL, url.Values{"url": {"https://example.com/doc"}})
+	if again := shorten(t, newClient(t), server.URL, url.Values{"url": {"https://example.com/doc"}}); again != plain {
+		t.Fatalf("got %s for the same url, want %s", again, plain)
+	}
+	expiring := shorten(t, newClient(t), server.URL, url.Values{"url": {"https://example.com/doc"}, "expiresAt": {"2999-01-01T00:00"}})
+	if expiring == plain {
+		t.Fatal("an expiring link reused one that never expires")
+	}
+}
+
+func TestProtectedLinksAreNeverShared(t *testing.T) {
+	config := testConfig
+	config.Dedupe = true
+	config.ShortCodes.Strategy = webserver.StrategyHash
+	store := database.NewMemoryStore()
+	server := newTestServerWith(t, config, store)
+
+	bob := newClient(t)
+	post(t, bob, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+	open := shorten(t, bob, server.URL, url.Values{"url": {"https://example.com/doc"}})
+
+	alice := newClient(t)
+	post(t, alice, server.URL+"/createUser", url.Values{"username": {"alice"}, "password": {"pw"}})
+	protected := shorten(t, alice, server.URL, url.Values{"url": {"https://example.com/doc"}, "password": {"secret"}})
+	if protected == open {
+		t.Fatal("a password protected link reused an open one")
+	}
+	record, err := store.GetUrl(protected)
+	if err != nil || !record.CheckPassword("secret") || record.CheckPassword("") {
+		t.Fatalf("got %+v, %v", record, err)
+	}
+	if !store.VerifyOwns("alice", protected) {
+		t.Fatal("protected link was not made alice's")
+	}
+
+	if again := shorten(t, bob, server.URL, url.Values{"url": {"https://example.com/doc"}, "password": {"secret"}}); again == open || again == protected {
+		t.Fatal("dedupe reused a link for a password protected request")
+	}
+}
+
+func TestHashCodesAvoidBlockedWords(t *testing.T) {
+	config := testConfig
+	config.ShortCodes.Strategy = webserver.StrategyHash
+	server := newTestServerWith(t, config, database.NewMemoryStore())
+	blocked := shorten(t, newClient(t), server.URL, url.Values{"url": {"https://example.com/doc"}})
+
+	config.CustomCodes.Blocklist = []string{blocked}
+	server = newTestServerWith(t, config, database.NewMemoryStore())
+	if short := shorten(t, newClient(t), server.URL, url.Values{"url": {"https://example.com/doc"}}); short == blocked {
+		t.Fatalf("got the blocked code %s", short)
+	}
+}
+
+func TestHashCodesAreNotSharedWithOtherUsers(t *testing.T) {
+	config := testConfig
+	config.ShortCodes.Strategy = webserver.StrategyHash
+	store := database.NewMemoryStore()
+	server := newTestServerWith(t, config, store)
+
+	anonymous := shorten(t, newClient(t), server.URL, url.Values{"url": {"https://example.com/open"}})
+	bob := newClient(t)
+	post(t, bob, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+	if short := shorten(t, bob, server.URL, url.Values{"url": {"https://example.com/open"}}); short != anonymous {
+		t.Fatalf("got %s for an anonymous link's url, want %s", short, anonymous)
+	}
+
+	bobs := shorten(t, bob, server.URL, url.Values{"url": {"https://example.com/doc"}})
+	if again := shorten(t, bob, server.URL, url.Values{"url": {"https://example.com/doc"}}); again != bobs {
+		t.Fatalf("got %s for bob's own url, want %s", again, bobs)
+	}
+	alice := newClient(t)
+	post(t, alice, server.URL+"/createUser", url.Values{"username": {"alice"}, "password": {"pw"}})
+	if short := shorten(t, alice, server.URL, url.Values{"url": {"https://example.com/doc"}}); short == bobs {
+		t.Fatal("alice was handed bob's link")
+	} else if !store.VerifyOwns("alice", short) {
+		t.Fatal("the new link was not made alice's")
+	}
+	if short := shorten(t, newClient(t), server.URL, url.Values{"url": {"https://example.com/doc"}}); short == bobs {
+		t.Fatal("an anonymous visitor was handed bob's link")
+	}
+}
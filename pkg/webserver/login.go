@@ -11,11 +11,12 @@ import (
 type loginInformation struct {
 	ErrorHappened bool
 	Error         string
+	Notice        string
 }
 
-const loginTemplateLocation = "pkg/webserver/templates/login.html"
+const loginTemplateLocation = "templates/login.html"
 
-var loginTemplate = template.Must(template.ParseFiles(loginTemplateLocation))
+var loginTemplate = template.Must(template.ParseFS(templates, loginTemplateLocation))
 
 func showLoginPage(res http.ResponseWriter, req *http.Request) {
 	info := new(loginInformation)
@@ -32,10 +33,21 @@ func showLoginPage(res http.ResponseWriter, req *http.Request) {
 		})
 	}
 
+	noticeCookie, err := req.Cookie("notice")
+	if err == nil {
+		info.Notice = noticeCookie.Value
+		http.SetCookie(res, &http.Cookie{
+			Name:    "notice",
+			Value:   "",
+			Expires: time.Now(),
+			Path:    routeMain,
+		})
+	}
+
 	loginTemplate.Execute(res, info)
 }
 
-func handleLogin(res http.ResponseWriter, req *http.Request) {
+func (s *server) handleLogin(res http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 	usernames, ok := req.Form["username"]
 	if !ok || len(usernames) == 0 || len(usernames[0]) == 0 {
@@ -64,7 +76,7 @@ func handleLogin(res http.ResponseWriter, req *http.Request) {
 	username := usernames[0]
 	password := passwords[0]
 
-	found := database.VerifyUser(username, password)
+	found := database.VerifyUser(s.store, username, password)
 	if !found {
 		http.SetCookie(res, &http.Cookie{
 			Name:    "error",
@@ -76,7 +88,26 @@ func handleLogin(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	signInUser(username, res, req)
+	s.signInUser(username, res, req)
+
+	cancelled, err := s.cancelDeletion(username)
+	if err != nil {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   err.Error(),
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+	} else if cancelled {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "deletion",
+			Value:   "Your account is no longer going to be deleted",
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+		http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+		return
+	}
 
 	http.Redirect(res, req, routeMain, http.StatusSeeOther)
 }
@@ -86,11 +117,11 @@ func handleLogout(res http.ResponseWriter, req *http.Request) {
 	http.Redirect(res, req, routeMain, http.StatusSeeOther)
 }
 
-func signInUser(username string, res http.ResponseWriter, req *http.Request) (string, error) {
+func (s *server) signInUser(username string, res http.ResponseWriter, req *http.Request) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"username": username,
 	})
-	signedString, err := token.SignedString(jwtSecret)
+	signedString, err := token.SignedString(s.jwtSecret)
 	if err != nil {
 		http.SetCookie(res, &http.Cookie{
 			Name:    "error",
@@ -1,8 +1,6 @@
 package webserver
 
 import (
-	"fmt"
-	"github.com/gorilla/mux"
 	"html/template"
 	"net/http"
 	"time"
@@ -15,14 +13,17 @@ type myURLsInformation struct {
 	ErrorHappened    bool
 	Error            string
 	URLs             []database.Record
+	Stats            map[string]database.ClickStats
+	Campaigns        []database.Campaign
+	Trash            []trashedLink
 	LoggedInAs       string
 }
 
-const myURLsTemplateLocation = "pkg/webserver/templates/myURLs.html"
+const myURLsTemplateLocation = "templates/myURLs.html"
 
-var myURLsTemplate = template.Must(template.ParseFiles(myURLsTemplateLocation))
+var myURLsTemplate = template.Must(template.ParseFS(templates, myURLsTemplateLocation))
 
-func showMyLinksPage(res http.ResponseWriter, req *http.Request) {
+func (s *server) showMyLinksPage(res http.ResponseWriter, req *http.Request) {
 	info := new(myURLsInformation)
 
 	deletionCookie, err := req.Cookie("deletion")
@@ -49,42 +50,35 @@ func showMyLinksPage(res http.ResponseWriter, req *http.Request) {
 		})
 	}
 
-	user, _ := verifyUsernameCookie(res, req)
+	user, _ := s.verifyUsernameCookie(res, req)
 	info.LoggedInAs = user
-	urls, err := database.GetURLsOf(user)
+	urls, err := s.store.GetURLsOf(user)
 	if err != nil {
 		info.ErrorHappened = true
 		info.Error = err.Error()
 	}
 	info.URLs = urls
 
-	myURLsTemplate.Execute(res, info)
-}
+	stats, err := s.store.ClickStatsOf(user)
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = err.Error()
+	}
+	info.Stats = stats
 
-func deleteURLRouteHandler(res http.ResponseWriter, req *http.Request) {
-	// TODO: user can delete their owned URLs
-	vars := mux.Vars(req)
-	shortened, _ := vars["key"]
-	username, _ := verifyUsernameCookie(res, req)
-	ok := database.VerifyOwns(username, shortened)
-	if ok {
-		err := database.DeleteURL(shortened)
-		if err != nil {
-			fmt.Println(err)
-		}
-		http.SetCookie(res, &http.Cookie{
-			Name:    "deletion",
-			Value:   "Deleted shortened URL",
-			Expires: time.Now().Add(time.Minute),
-			Path:    routeMain,
-		})
-	} else {
-		http.SetCookie(res, &http.Cookie{
-			Name:    "error",
-			Value:   "URL not owned by you",
-			Expires: time.Now().Add(time.Minute),
-			Path:    routeMain,
-		})
+	campaigns, err := s.store.GetCampaigns(user)
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = err.Error()
+	}
+	info.Campaigns = campaigns
+
+	trash, err := s.trashOf(user)
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = err.Error()
 	}
-	http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+	info.Trash = trash
+
+	myURLsTemplate.Execute(res, info)
 }
@@ -0,0 +1,27 @@
+package webserver
+
+import (
+	"net/url"
+	"strings"
+)
+
+// normaliseURL puts a URL into a canonical form so the same destination typed
+// slightly differently compares equal: the scheme and host are lowercased, a
+// trailing slash is dropped from the path and query parameters are sorted by
+// key. URLs that fail to parse are returned unchanged.
+func normaliseURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	if u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+
+	return u.String()
+}
@@ -0,0 +1,64 @@
+package webserver
+
+import (
+	"github.com/gorilla/mux"
+	"html/template"
+	"net/http"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+type previewInformation struct {
+	Short       string
+	Destination string
+	Varies      bool
+	Protected   bool
+	Forced      bool
+	Created     time.Time
+	Owner       string
+	Clicks      int64
+}
+
+const previewTemplateLocation = "templates/preview.html"
+
+var previewTemplate = template.Must(template.ParseFS(templates, previewTemplateLocation))
+
+func (s *server) previewRouteHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		shortened := mux.Vars(req)["key"]
+		record, ok := s.findLink(res, req, shortened)
+		if !ok {
+			return
+		}
+		if record.Expired(time.Now()) {
+			showExpiredPage(res, req, record)
+			return
+		}
+		s.showPreviewPage(res, req, record, false, false)
+	default:
+		http.Redirect(res, req, routeMain, http.StatusSeeOther)
+	}
+}
+
+// showPreviewPage describes where a link goes without following it or
+// counting a click. forced is set when the owner asked for every visitor to
+// see the page, which then confirms by posting back to the short link. The
+// destination of a password protected link stays hidden until the visitor
+// has unlocked it, which unlocked reports for a password sent with req.
+func (s *server) showPreviewPage(res http.ResponseWriter, req *http.Request, record database.Record, forced, unlocked bool) {
+	info := &previewInformation{
+		Short:     record.Short,
+		Varies:    len(record.Rules) > 0 || len(record.Variants) > 0,
+		Protected: record.Password != "" && !unlocked && !s.hasLinkAccess(req, record.Short),
+		Forced:    forced,
+		Created:   record.Created,
+		Clicks:    record.Clicks,
+	}
+	if !info.Protected {
+		info.Destination = record.Long
+	}
+	info.Owner, _ = s.store.OwnerOf(record.Short)
+
+	previewTemplate.Execute(res, info)
+}
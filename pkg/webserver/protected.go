@@ -0,0 +1,116 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"html/template"
+	"net/http"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+type passwordInformation struct {
+	ErrorHappened bool
+	Error         string
+	Short         string
+}
+
+const (
+	passwordTemplateLocation = "templates/password.html"
+	linkAccessCookieName     = "link_access"
+	linkAccessDuration       = 15 * time.Minute
+)
+
+var passwordTemplate = template.Must(template.ParseFS(templates, passwordTemplateLocation))
+
+// unlockLink reports whether the visitor may follow a password protected
+// link. Visitors holding an access cookie for it go straight through. Anyone
+// else is shown the password prompt, which posts back to the short link, and
+// the right password earns them a cookie so they are not asked again for a
+// while. justUnlocked is set when that cookie was only granted by this
+// request, so req itself does not carry it yet. When ok is false the response
+// has already been written.
+func (s *server) unlockLink(res http.ResponseWriter, req *http.Request, record database.Record) (ok, justUnlocked bool) {
+	if record.Password == "" || s.hasLinkAccess(req, record.Short) {
+		return true, false
+	}
+
+	info := &passwordInformation{Short: record.Short}
+	if req.Method != http.MethodPost {
+		passwordTemplate.Execute(res, info)
+		return false, false
+	}
+
+	req.ParseForm()
+	if !record.CheckPassword(req.Form.Get("password")) {
+		info.ErrorHappened = true
+		info.Error = "incorrect password"
+		res.WriteHeader(http.StatusForbidden)
+		passwordTemplate.Execute(res, info)
+		return false, false
+	}
+
+	err := s.grantLinkAccess(res, record.Short)
+	if err != nil {
+		info.ErrorHappened = true
+		info.Error = "err with signing cookie"
+		res.WriteHeader(http.StatusInternalServerError)
+		passwordTemplate.Execute(res, info)
+		return false, false
+	}
+	return true, true
+}
+
+// grantLinkAccess sets a signed cookie, scoped to the short link, that lets
+// the visitor past its password prompt until it expires.
+func (s *server) grantLinkAccess(res http.ResponseWriter, shortened string) error {
+	expires := time.Now().Add(linkAccessDuration)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"short": shortened,
+		"exp":   expires.Unix(),
+	})
+	signedString, err := token.SignedString(s.linkAccessSecret)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     linkAccessCookieName,
+		Value:    signedString,
+		Expires:  expires,
+		Path:     redirectPath(shortened),
+		HttpOnly: true,
+	})
+	return nil
+}
+
+func (s *server) hasLinkAccess(req *http.Request, shortened string) bool {
+	cookie, err := req.Cookie(linkAccessCookieName)
+	if err != nil {
+		return false
+	}
+	token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
+		_, ok := token.Method.(*jwt.SigningMethodHMAC)
+		if !ok {
+			return nil, fmt.Errorf("method not valid")
+		}
+		return s.linkAccessSecret, nil
+	})
+	if err != nil {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return false
+	}
+	short, _ := claims["short"].(string)
+	return short == shortened
+}
+
+// deriveKey derives a key for one purpose from the server secret.
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
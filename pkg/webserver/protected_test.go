@@ -0,0 +1,74 @@
+package webserver_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"urlShortener/pkg/database"
+)
+
+func TestUnlockingShowsInterstitialDestination(t *testing.T) {
+	server := newTestServer(t, database.NewMemoryStore())
+	owner := newClient(t)
+	post(t, owner, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+	post(t, owner, server.URL+"/", url.Values{"url": {"https://example.com/docs"}, "urlRequest": {"docs"}, "password": {"secret"}, "passthrough": {"on"}})
+	post(t, owner, server.URL+"/profile/links/docs/interstitial", url.Values{"action": {"on"}})
+
+	visitor := newClient(t)
+	res, err := visitor.PostForm(server.URL+"/u/docs/guide?lang=en", url.Values{"password": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(body)
+	if !strings.Contains(page, "https://example.com/docs") {
+		t.Fatal("interstitial hid the destination after the password was entered")
+	}
+	if strings.Contains(page, `href="/u/docs"`) {
+		t.Fatal("interstitial offered a GET continue link that drops the passthrough path")
+	}
+
+	res = post(t, visitor, server.URL+"/u/docs/guide?lang=en", url.Values{"confirm": {"1"}})
+	if location := res.Header.Get("Location"); location != "https://example.com/docs/guide?lang=en" {
+		t.Fatalf("redirected to %q", location)
+	}
+}
+
+func TestLinkAccessIsNotALogin(t *testing.T) {
+	server := newTestServer(t, database.NewMemoryStore())
+	owner := newClient(t)
+	post(t, owner, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+	post(t, owner, server.URL+"/", url.Values{"url": {"https://example.com/docs"}, "urlRequest": {"docs"}, "password": {"secret"}})
+
+	visitor := newClient(t)
+	res := post(t, visitor, server.URL+"/u/docs", url.Values{"password": {"secret"}})
+	var access *http.Cookie
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "link_access" {
+			access = cookie
+		}
+	}
+	if access == nil {
+		t.Fatal("no link access cookie was granted")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/profile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "login", Value: access.Value})
+	res, err = visitor.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		t.Fatal("a link access token was accepted as a login")
+	}
+}
@@ -0,0 +1,124 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+const (
+	DefaultRedirectStatus = http.StatusFound
+
+	// permanentRedirectMaxAge caps how long clients may cache a 301 or 308,
+	// so that a link that is edited or deleted does not stay cached forever.
+	permanentRedirectMaxAge = 24 * time.Hour
+)
+
+// redirectStatuses are the redirect types links may use, in the order the UI
+// offers them.
+var redirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+func validRedirectStatus(status int) bool {
+	for _, s := range redirectStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRedirectStatus reads a redirect type sent from a form, where an empty
+// value means the server default.
+func parseRedirectStatus(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	status, err := strconv.Atoi(value)
+	if err != nil || !validRedirectStatus(status) {
+		return 0, fmt.Errorf("redirect type must be one of 301, 302, 307 or 308")
+	}
+	return status, nil
+}
+
+// redirectStatusFor gives the status to redirect req with. Requests posted
+// from the password prompt or the interstitial always get a 303, as a 307 or
+// 308 would repeat the post against the destination.
+func (s *server) redirectStatusFor(record database.Record, req *http.Request) int {
+	if req.Method == http.MethodPost {
+		return http.StatusSeeOther
+	}
+	if record.RedirectStatus != 0 {
+		return record.RedirectStatus
+	}
+	return s.config.RedirectStatus
+}
+
+// setCacheControl lets clients cache permanent redirects for a while, but
+// only where that cannot skip something the link needs to do on every visit:
+// count towards a click limit, outlive an expiry date, ask for a password or
+// pick a destination per visitor. Temporary redirects are never cached.
+func setCacheControl(res http.ResponseWriter, record database.Record, status int) {
+	permanent := status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+	if !permanent || record.MaxClicks > 0 || record.Password != "" {
+		res.Header().Set("Cache-Control", "private, no-cache")
+		return
+	}
+
+	maxAge := permanentRedirectMaxAge
+	if !record.ExpiresAt.IsZero() {
+		untilExpiry := time.Until(record.ExpiresAt)
+		if untilExpiry < maxAge {
+			maxAge = untilExpiry
+		}
+	}
+	scope := "public"
+	if len(record.Rules) > 0 || len(record.Variants) > 0 {
+		scope = "private"
+	}
+	res.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+}
+
+// passThrough appends rest, the path after the short code, and the visitor's
+// query to destination. Parameters the destination already sets keep their
+// value rather than being overridden by the visitor's.
+func passThrough(destination, rest string, query url.Values) string {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	if rest != "" {
+		segments := strings.Split(rest, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		escaped := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + strings.Join(segments, "/")
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + rest
+		u.RawPath = escaped
+	}
+
+	existing := u.Query()
+	extra := url.Values{}
+	for key, values := range query {
+		_, ok := existing[key]
+		if !ok {
+			extra[key] = values
+		}
+	}
+	if len(extra) > 0 {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += extra.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,253 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+const (
+	StrategyRandom  = "random"
+	StrategyCounter = "counter"
+	StrategyHashids = "hashids"
+	StrategyHash    = "hash"
+
+	DefaultAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	shortCodeAttempts = 12
+)
+
+// ShortCodeConfig picks how short codes are generated when the user does not
+// request one. Length is the starting length for random codes, the minimum
+// length for counter and hashids codes, and the exact length of hash codes
+// before any collision forces them longer.
+type ShortCodeConfig struct {
+	Strategy  string
+	Alphabet  string
+	Length    int
+	MaxLength int
+	Salt      string
+}
+
+// shortCodeStrategy produces candidate short codes for a long URL. attempt
+// counts the collisions already seen for this URL so a strategy can vary or
+// lengthen its output. Deterministic strategies always give the same code for
+// the same long URL and attempt.
+type shortCodeStrategy interface {
+	generate(long string, attempt int) (string, error)
+	deterministic() bool
+}
+
+func newShortCodeStrategy(config ShortCodeConfig, store database.Store) (shortCodeStrategy, error) {
+	if config.Alphabet == "" {
+		config.Alphabet = DefaultAlphabet
+	}
+	if len(config.Alphabet) < 2 {
+		return nil, fmt.Errorf("short code alphabet must have at least 2 characters")
+	}
+	if config.Length <= 0 {
+		return nil, fmt.Errorf("short code length must be positive")
+	}
+	if config.MaxLength < config.Length {
+		config.MaxLength = config.Length
+	}
+
+	switch config.Strategy {
+	case StrategyRandom, "":
+		return randomStrategy{config}, nil
+	case StrategyCounter:
+		return counterStrategy{config, store}, nil
+	case StrategyHashids:
+		return hashidsStrategy{config, store}, nil
+	case StrategyHash:
+		return hashStrategy{config}, nil
+	default:
+		return nil, fmt.Errorf("unknown short code strategy %q", config.Strategy)
+	}
+}
+
+// addGeneratedURL stores record under a generated short code and returns the
+// code, retrying with a new candidate whenever one is already taken or
+// contains a reserved or blocked word. created is false when a deterministic
+// strategy led back to an existing record for the same long URL with the same
+// settings, which is then shared rather than duplicated. Only records made
+// anonymously or by owner, who is empty for anonymous links, are shared, so
+// nobody is handed a link someone else can edit.
+func (s *server) addGeneratedURL(record database.Record, owner string) (shortened string, created bool, err error) {
+	for attempt := 0; attempt < shortCodeAttempts; attempt++ {
+		shortened, err = s.shortCodes.generate(destinationKey(record), attempt)
+		if err != nil {
+			return "", false, err
+		}
+		if !s.customCodes.allowsGenerated(shortened) {
+			continue
+		}
+		record.Short = shortened
+		err = s.store.AddURL(record)
+		if err == nil {
+			return shortened, true, nil
+		}
+		if err != database.ErrURLTaken {
+			return "", false, err
+		}
+		if s.shortCodes.deterministic() {
+			existing, err := s.store.GetUrl(shortened)
+			if err == nil && destinationKey(existing) == destinationKey(record) && reusable(existing, record, time.Now()) {
+				madeBy, err := s.store.OwnerOf(shortened)
+				if err == nil && (madeBy == "" || madeBy == owner) {
+					return shortened, false, nil
+				}
+			}
+		}
+	}
+	return "", false, fmt.Errorf("could not find a free shortened url")
+}
+
+// randomStrategy draws each character uniformly from the alphabet with
+// crypto/rand. Every three collisions the code grows by a character, up to
+// MaxLength, so a crowded keyspace does not exhaust the retry budget.
+type randomStrategy struct {
+	config ShortCodeConfig
+}
+
+func (s randomStrategy) generate(long string, attempt int) (string, error) {
+	length := s.config.Length + attempt/3
+	if length > s.config.MaxLength {
+		length = s.config.MaxLength
+	}
+
+	max := big.NewInt(int64(len(s.config.Alphabet)))
+	res := make([]byte, length)
+	for i := range res {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		res[i] = s.config.Alphabet[n.Int64()]
+	}
+	return string(res), nil
+}
+
+func (s randomStrategy) deterministic() bool {
+	return false
+}
+
+// counterStrategy encodes the store's next sequential id in the alphabet,
+// giving the shortest possible codes.
+type counterStrategy struct {
+	config ShortCodeConfig
+	store  database.Store
+}
+
+func (s counterStrategy) generate(long string, attempt int) (string, error) {
+	id, err := s.store.NextID()
+	if err != nil {
+		return "", err
+	}
+	return encodeNumber(id, s.config.Alphabet, s.config.Length), nil
+}
+
+func (s counterStrategy) deterministic() bool {
+	return false
+}
+
+// hashidsStrategy encodes the store's next sequential id like hashids does:
+// the alphabet is shuffled with the salt, a lottery character picked from the
+// id is prepended, and the rest is encoded with the alphabet reshuffled by
+// that lottery character. Consecutive ids therefore look unrelated, but the
+// lottery character plus the body still decode to exactly one id, so codes
+// never collide with each other.
+type hashidsStrategy struct {
+	config ShortCodeConfig
+	store  database.Store
+}
+
+func (s hashidsStrategy) generate(long string, attempt int) (string, error) {
+	id, err := s.store.NextID()
+	if err != nil {
+		return "", err
+	}
+
+	alphabet := consistentShuffle(s.config.Alphabet, s.config.Salt)
+	lottery := alphabet[id%uint64(len(alphabet))]
+	alphabet = consistentShuffle(alphabet, string(lottery)+s.config.Salt)
+	return string(lottery) + encodeNumber(id, alphabet, s.config.Length-1), nil
+}
+
+func (s hashidsStrategy) deterministic() bool {
+	return false
+}
+
+// hashStrategy derives the code from a SHA-256 of the long URL, so the same
+// destination always shortens to the same code. Later attempts hash the long
+// URL together with the attempt number and take one more character per
+// attempt, up to MaxLength, so a collision or a blocked word in the first code
+// does not carry over to the next.
+type hashStrategy struct {
+	config ShortCodeConfig
+}
+
+func (s hashStrategy) generate(long string, attempt int) (string, error) {
+	length := s.config.Length + attempt
+	if length > s.config.MaxLength {
+		length = s.config.MaxLength
+	}
+
+	input := long
+	if attempt > 0 {
+		input += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(input))
+	n := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(s.config.Alphabet)))
+	digit := new(big.Int)
+
+	res := make([]byte, length)
+	for i := range res {
+		n.DivMod(n, base, digit)
+		res[i] = s.config.Alphabet[digit.Int64()]
+	}
+	return string(res), nil
+}
+
+func (s hashStrategy) deterministic() bool {
+	return true
+}
+
+// encodeNumber writes n in the base given by the alphabet, left padding with
+// the alphabet's zero character up to minLength.
+func encodeNumber(n uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+
+	var res []byte
+	for n > 0 {
+		res = append([]byte{alphabet[n%base]}, res...)
+		n /= base
+	}
+	for len(res) < minLength {
+		res = append([]byte{alphabet[0]}, res...)
+	}
+	return string(res)
+}
+
+// consistentShuffle is the salted Fisher-Yates shuffle used by hashids.
+func consistentShuffle(alphabet, salt string) string {
+	if salt == "" {
+		return alphabet
+	}
+
+	res := []byte(alphabet)
+	for i, v, p := len(res)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		integer := int(salt[v])
+		p += integer
+		j := (integer + v + p) % i
+		res[i], res[j] = res[j], res[i]
+		v++
+	}
+	return string(res)
+}
@@ -0,0 +1,131 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"urlShortener/pkg/database"
+)
+
+const (
+	RuleOS       = "os"
+	RuleDevice   = "device"
+	RuleCountry  = "country"
+	RuleLanguage = "language"
+)
+
+// ruleKinds lists the targeting rule kinds in the order the UI offers them.
+var ruleKinds = []string{RuleOS, RuleDevice, RuleCountry, RuleLanguage}
+
+// destinationFor picks where the visitor making req should be sent: the
+// destination of the first targeting rule they match, else their A/B variant
+// if the record is split, else the record's Long. The variant served is noted
+// on click.
+func destinationFor(record database.Record, res http.ResponseWriter, req *http.Request, click *database.Click) string {
+	for _, rule := range record.Rules {
+		if ruleMatches(rule, req, *click) {
+			return rule.Destination
+		}
+	}
+	variant, ok := pickVariant(res, req, record)
+	if ok {
+		click.Variant = variant.Name
+		return variant.Destination
+	}
+	return record.Long
+}
+
+func ruleMatches(rule database.TargetRule, req *http.Request, click database.Click) bool {
+	switch rule.Kind {
+	case RuleOS:
+		return strings.EqualFold(osName(req.UserAgent()), rule.Value)
+	case RuleDevice:
+		return strings.EqualFold(deviceType(req.UserAgent()), rule.Value)
+	case RuleCountry:
+		return click.Country != "" && strings.EqualFold(click.Country, rule.Value)
+	case RuleLanguage:
+		return languageMatches(req.Header.Get("Accept-Language"), rule.Value)
+	default:
+		return false
+	}
+}
+
+// languageMatches reports whether the visitor's most preferred language is
+// want. A bare language such as "fr" matches any region of it, while "fr-CA"
+// only matches that region.
+func languageMatches(acceptLanguage, want string) bool {
+	languages := preferredLanguages(acceptLanguage)
+	if len(languages) == 0 {
+		return false
+	}
+	have := strings.ToLower(languages[0])
+	want = strings.ToLower(want)
+	return have == want || strings.HasPrefix(have, want+"-")
+}
+
+// preferredLanguages parses an Accept-Language header into its language tags,
+// most preferred first.
+func preferredLanguages(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+
+	var languages []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			languages = append(languages, weighted{tag, quality})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	tags := make([]string, len(languages))
+	for i, language := range languages {
+		tags[i] = language.tag
+	}
+	return tags
+}
+
+// newTargetRule validates a rule submitted by a link's owner.
+func (s *server) newTargetRule(kind, value, destination string) (database.TargetRule, error) {
+	known := false
+	for _, ruleKind := range ruleKinds {
+		known = known || kind == ruleKind
+	}
+	if !known {
+		return database.TargetRule{}, fmt.Errorf("unknown rule type")
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return database.TargetRule{}, fmt.Errorf("rules must have a value to match")
+	}
+	_, err := url.ParseRequestURI(destination)
+	if err != nil {
+		return database.TargetRule{}, fmt.Errorf("please enter a valid url")
+	}
+	err = s.destinations.check(destination)
+	if err != nil {
+		return database.TargetRule{}, err
+	}
+	return database.TargetRule{Kind: kind, Value: value, Destination: destination}, nil
+}
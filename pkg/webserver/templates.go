@@ -0,0 +1,9 @@
+package webserver
+
+import "embed"
+
+// templates holds the pages served by the webserver, built into the binary
+// so it can run from any directory.
+//
+//go:embed templates/*.html
+var templates embed.FS
@@ -0,0 +1,116 @@
+package webserver
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+// trashedLink is a link in the trash along with when it will be purged, which
+// is zero when the trash is kept until emptied by hand.
+type trashedLink struct {
+	Record  database.Record
+	PurgeAt time.Time
+}
+
+func (s *server) deleteURLRouteHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		s.handleTrashURL(res, req)
+	default:
+		http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+	}
+}
+
+func (s *server) handleTrashURL(res http.ResponseWriter, req *http.Request) {
+	s.changeTrash(res, req, "Moved shortened URL to the trash", func(shortened string) error {
+		return s.store.TrashURL(shortened, time.Now())
+	})
+}
+
+func (s *server) handleRestoreURL(res http.ResponseWriter, req *http.Request) {
+	s.changeTrash(res, req, "Restored shortened URL", func(shortened string) error {
+		err := s.store.RestoreURL(shortened)
+		if err == database.ErrURLTaken {
+			return fmt.Errorf("That shortened URL has been taken since it was deleted")
+		}
+		return err
+	})
+}
+
+func (s *server) handlePurgeURL(res http.ResponseWriter, req *http.Request) {
+	s.changeTrash(res, req, "Deleted shortened URL for good", s.store.DeleteURL)
+}
+
+// changeTrash applies change to a link the user owns and sends them back to
+// their profile, reporting success with message.
+func (s *server) changeTrash(res http.ResponseWriter, req *http.Request, message string, change func(shortened string) error) {
+	shortened := mux.Vars(req)["key"]
+	username, _ := s.verifyUsernameCookie(res, req)
+	if !s.store.VerifyOwns(username, shortened) {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   "URL not owned by you",
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+		http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+		return
+	}
+
+	err := change(shortened)
+	if err != nil {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   err.Error(),
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+	} else {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "deletion",
+			Value:   message,
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+	}
+	http.Redirect(res, req, routeMyLinks, http.StatusSeeOther)
+}
+
+func (s *server) trashOf(username string) ([]trashedLink, error) {
+	records, err := s.store.GetTrashOf(username)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]trashedLink, len(records))
+	for i, record := range records {
+		links[i].Record = record
+		if s.config.TrashRetention > 0 {
+			links[i].PurgeAt = record.DeletedAt.Add(s.config.TrashRetention)
+		}
+	}
+	return links, nil
+}
+
+// purgeTrash periodically deletes links that have been in the trash for
+// longer than retention until stop is closed.
+func (s *server) purgeTrash(interval, retention time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			count, err := s.store.PurgeTrash(time.Now().Add(-retention))
+			if err != nil {
+				fmt.Println(err)
+			} else if count > 0 {
+				fmt.Printf("purged %d links from the trash\n", count)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
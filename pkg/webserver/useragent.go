@@ -0,0 +1,66 @@
+package webserver
+
+import "strings"
+
+// browserName picks the browser family out of a User-Agent header. Order
+// matters, as most browsers also claim to be the ones they are built on.
+func browserName(userAgent string) string {
+	switch {
+	case userAgent == "":
+		return "Unknown"
+	case strings.Contains(userAgent, "Edg/"), strings.Contains(userAgent, "Edge/"):
+		return "Edge"
+	case strings.Contains(userAgent, "OPR/"), strings.Contains(userAgent, "Opera"):
+		return "Opera"
+	case strings.Contains(userAgent, "SamsungBrowser/"):
+		return "Samsung Internet"
+	case strings.Contains(userAgent, "Firefox/"), strings.Contains(userAgent, "FxiOS/"):
+		return "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		return "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		return "Safari"
+	case strings.Contains(userAgent, "MSIE "), strings.Contains(userAgent, "Trident/"):
+		return "Internet Explorer"
+	case strings.HasPrefix(userAgent, "curl/"):
+		return "curl"
+	default:
+		return "Other"
+	}
+}
+
+// osName picks the operating system out of a User-Agent header.
+func osName(userAgent string) string {
+	switch {
+	case userAgent == "":
+		return "Unknown"
+	case strings.Contains(userAgent, "Android"):
+		return "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return "iOS"
+	case strings.Contains(userAgent, "Windows"):
+		return "Windows"
+	case strings.Contains(userAgent, "Mac OS X"), strings.Contains(userAgent, "Macintosh"):
+		return "macOS"
+	case strings.Contains(userAgent, "CrOS"):
+		return "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		return "Linux"
+	default:
+		return "Other"
+	}
+}
+
+// deviceType classes a User-Agent header as mobile, tablet or desktop.
+func deviceType(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "Tablet"):
+		return "tablet"
+	case strings.Contains(userAgent, "Android") && !strings.Contains(userAgent, "Mobile"):
+		return "tablet"
+	case strings.Contains(userAgent, "Mobi"), strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPod"):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
@@ -0,0 +1,126 @@
+package webserver
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gorilla/mux"
+	"os"
+	"strings"
+)
+
+const DefaultCustomAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// extraReservedWords are kept free for routes that do not exist yet, on top
+// of the first path segment of every route registered on the router.
+var extraReservedWords = []string{"admin", "api", "static", "assets"}
+
+// CustomCodeConfig restricts the short codes users may request for themselves.
+// Blocklist is a list of words that may not appear anywhere in a code.
+type CustomCodeConfig struct {
+	Alphabet  string
+	MinLength int
+	MaxLength int
+	Blocklist []string
+}
+
+type codeValidator struct {
+	config   CustomCodeConfig
+	reserved map[string]bool
+}
+
+func newCodeValidator(config CustomCodeConfig, router *mux.Router) (*codeValidator, error) {
+	if config.Alphabet == "" {
+		config.Alphabet = DefaultCustomAlphabet
+	}
+	if config.MinLength <= 0 || config.MaxLength < config.MinLength {
+		return nil, fmt.Errorf("custom short code lengths must satisfy 0 < min <= max")
+	}
+	blocklist := make([]string, len(config.Blocklist))
+	for i, word := range config.Blocklist {
+		blocklist[i] = strings.ToLower(word)
+	}
+	config.Blocklist = blocklist
+
+	reserved := make(map[string]bool)
+	for _, word := range extraReservedWords {
+		reserved[word] = true
+	}
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		segment := strings.SplitN(strings.TrimPrefix(template, "/"), "/", 2)[0]
+		if segment != "" && !strings.Contains(segment, "{") {
+			reserved[strings.ToLower(segment)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &codeValidator{config: config, reserved: reserved}, nil
+}
+
+// validate checks a requested short code against the alphabet, length limits
+// and reserved and blocked words.
+func (v *codeValidator) validate(code string) error {
+	length := len([]rune(code))
+	if length < v.config.MinLength || length > v.config.MaxLength {
+		return fmt.Errorf("Shortened URL must be between %d and %d characters long", v.config.MinLength, v.config.MaxLength)
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(v.config.Alphabet, c) {
+			if v.config.Alphabet == DefaultCustomAlphabet {
+				return fmt.Errorf("Shortened URL may only contain letters, numbers, - and _")
+			}
+			return fmt.Errorf("Shortened URL may only contain the characters %s", v.config.Alphabet)
+		}
+	}
+	if v.reserved[strings.ToLower(code)] {
+		return fmt.Errorf("Shortened URL %s is reserved", code)
+	}
+	if v.blocked(code) {
+		return fmt.Errorf("Shortened URL contains a blocked word")
+	}
+	return nil
+}
+
+// allowsGenerated reports whether a generated code is free of reserved and
+// blocked words. Generated codes are not held to the custom alphabet or
+// lengths, which have their own settings.
+func (v *codeValidator) allowsGenerated(code string) bool {
+	return !v.reserved[strings.ToLower(code)] && !v.blocked(code)
+}
+
+func (v *codeValidator) blocked(code string) bool {
+	code = strings.ToLower(code)
+	for _, word := range v.config.Blocklist {
+		if word != "" && strings.Contains(code, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadWordList reads one word per line from path, skipping blank lines and
+// lines starting with #.
+func LoadWordList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
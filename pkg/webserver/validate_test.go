@@ -0,0 +1,19 @@
+package webserver_test
+
+import (
+	"testing"
+	"urlShortener/pkg/database"
+	"urlShortener/pkg/webserver"
+)
+
+func TestBlocklistIsNotModified(t *testing.T) {
+	config := testConfig
+	config.CustomCodes.Blocklist = []string{"Rude"}
+	_, err := webserver.NewHandler(config, database.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CustomCodes.Blocklist[0] != "Rude" {
+		t.Fatalf("blocklist was changed to %v", config.CustomCodes.Blocklist)
+	}
+}
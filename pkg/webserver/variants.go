@@ -0,0 +1,152 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"urlShortener/pkg/database"
+)
+
+const (
+	variantCookieName    = "variant"
+	variantCookieAge     = 90 * 24 * time.Hour
+	variantNameMaxLength = 32
+	variantMaxWeight     = 1000
+	variantNameAlphabet  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+)
+
+// pickVariant assigns the visitor making req one of the record's variants.
+// A visitor who has been assigned one before keeps it for as long as it is
+// still running, as remembered by a cookie scoped to the short link, and
+// anyone else is assigned at random by weight. ok is false when no variant
+// has any weight.
+func pickVariant(res http.ResponseWriter, req *http.Request, record database.Record) (database.Variant, bool) {
+	cookie, err := req.Cookie(variantCookieName)
+	if err == nil {
+		for _, variant := range record.Variants {
+			if variant.Name == cookie.Value && variant.Weight > 0 {
+				return variant, true
+			}
+		}
+	}
+
+	total := 0
+	for _, variant := range record.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return database.Variant{}, false
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(total)))
+	if err != nil {
+		return database.Variant{}, false
+	}
+
+	pick := int(n.Int64())
+	for _, variant := range record.Variants {
+		if pick < variant.Weight {
+			http.SetCookie(res, &http.Cookie{
+				Name:     variantCookieName,
+				Value:    variant.Name,
+				Expires:  time.Now().Add(variantCookieAge),
+				Path:     redirectPath(record.Short),
+				HttpOnly: true,
+			})
+			return variant, true
+		}
+		pick -= variant.Weight
+	}
+	return database.Variant{}, false
+}
+
+func redirectPath(shortened string) string {
+	return strings.Replace(routeRedirect, "{key}", url.PathEscape(shortened), 1)
+}
+
+// newVariant validates a variant submitted by a link's owner. An empty name is
+// given the first free letter.
+func (s *server) newVariant(name, destination, weight string, existing []database.Variant) (database.Variant, error) {
+	taken := make(map[string]bool)
+	for _, variant := range existing {
+		taken[variant.Name] = true
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		for c := 'A'; c <= 'Z' && name == ""; c++ {
+			if !taken[string(c)] {
+				name = string(c)
+			}
+		}
+	}
+	if name == "" || len(name) > variantNameMaxLength {
+		return database.Variant{}, fmt.Errorf("variant names must be between 1 and %d characters long", variantNameMaxLength)
+	}
+	for _, c := range name {
+		if !strings.ContainsRune(variantNameAlphabet, c) {
+			return database.Variant{}, fmt.Errorf("variant names may only contain letters, numbers, - and _")
+		}
+	}
+	if taken[name] {
+		return database.Variant{}, fmt.Errorf("there is already a variant called %s", name)
+	}
+
+	w, err := parseVariantWeight(weight)
+	if err != nil {
+		return database.Variant{}, err
+	}
+	_, err = url.ParseRequestURI(destination)
+	if err != nil {
+		return database.Variant{}, fmt.Errorf("please enter a valid url")
+	}
+	err = s.destinations.check(destination)
+	if err != nil {
+		return database.Variant{}, err
+	}
+	return database.Variant{Name: name, Destination: destination, Weight: w}, nil
+}
+
+func parseVariantWeight(weight string) (int, error) {
+	w, err := strconv.Atoi(strings.TrimSpace(weight))
+	if err != nil || w < 0 || w > variantMaxWeight {
+		return 0, fmt.Errorf("weights must be whole numbers from 0 to %d", variantMaxWeight)
+	}
+	return w, nil
+}
+
+// updateVariants adds or removes one of a link's variants or changes its
+// weight, depending on the action sent with the form. A weight of zero pauses
+// a variant without losing its clicks from the comparison.
+func (s *server) updateVariants(variants []database.Variant, form url.Values) ([]database.Variant, error) {
+	variants = append([]database.Variant(nil), variants...)
+	action := form.Get("action")
+	if action == "add" {
+		variant, err := s.newVariant(form.Get("name"), form.Get("destination"), form.Get("weight"), variants)
+		if err != nil {
+			return nil, err
+		}
+		return append(variants, variant), nil
+	}
+
+	i, err := strconv.Atoi(form.Get("index"))
+	if err != nil || i < 0 || i >= len(variants) {
+		return nil, fmt.Errorf("variant not found")
+	}
+	switch action {
+	case "delete":
+		return append(variants[:i:i], variants[i+1:]...), nil
+	case "weight":
+		variants[i].Weight, err = parseVariantWeight(form.Get("weight"))
+		if err != nil {
+			return nil, err
+		}
+		return variants, nil
+	default:
+		return nil, fmt.Errorf("unknown action")
+	}
+}
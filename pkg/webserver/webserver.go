@@ -1,9 +1,14 @@
 package webserver
 
 import (
+	"context"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/oschwald/geoip2-golang"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 	"urlShortener/pkg/database"
 )
@@ -15,41 +20,176 @@ const (
 	routeCreateUser = "/createUser"
 	routeDeleteUser = "/deleteUser"
 	routeMyLinks    = "/profile"
+	routeCampaigns  = "/profile/campaigns"
+	routeLinkDetail = "/profile/links/{key}"
+	routeLinkEdit   = "/profile/links/{key}/destination"
+	routeLinkUndo   = "/profile/links/{key}/rollback"
+	routeLinkRules  = "/profile/links/{key}/rules"
+	routeLinkSplit  = "/profile/links/{key}/variants"
+	routeLinkLock   = "/profile/links/{key}/password"
+	routeLinkWarn   = "/profile/links/{key}/interstitial"
+	routeLinkStatus = "/profile/links/{key}/redirect"
+	routeLinkPass   = "/profile/links/{key}/passthrough"
+	routeLinkUTM    = "/profile/links/{key}/utm"
 	routeRedirect   = "/u/{key}"
+	routeRedirectTo = "/u/{key}/{rest:.*}"
+	routePreview    = "/p/{key}"
+	routePreviewAlt = "/u/{key}+"
 	routeDeleteURL  = "/d/{key}"
+	routeRestoreURL = "/profile/trash/{key}/restore"
+	routePurgeURL   = "/profile/trash/{key}/purge"
+	routeAPIURLs    = "/api/urls"
+	routeAPIURL     = "/api/urls/{key}"
 )
 
-var jwtSecret []byte
+const shutdownTimeout = 10 * time.Second
 
-func Run(secret string) {
-	jwtSecret = []byte(secret)
-	server := create()
-	err := server.ListenAndServe()
+// server holds everything the handlers share, so that each handler built by
+// NewHandler is independent of any other in the same process.
+type server struct {
+	config    Config
+	store     database.Store
+	jwtSecret []byte
+	// linkAccessSecret signs link access cookies. It is derived from
+	// jwtSecret but differs from it, so a link access token can never pass
+	// for a login.
+	linkAccessSecret []byte
+	shortCodes       shortCodeStrategy
+	customCodes      *codeValidator
+	destinations     *destinationPolicy
+	// geoDB resolves client addresses to locations from a local
+	// MaxMind-format database. It is nil when no database is configured, in
+	// which case clicks simply have no location.
+	geoDB      *geoip2.Reader
+	clickQueue *clickRecorder
+	handler    http.Handler
+}
+
+func Run(config Config, store database.Store) {
+	s, err := newServer(config, store)
 	if err != nil {
 		fmt.Println(err)
+		return
+	}
+	if s.geoDB != nil {
+		defer s.geoDB.Close()
+	}
+	if config.Clicks.BufferSize > 0 {
+		s.clickQueue = newClickRecorder(config.Clicks, store)
+		defer s.clickQueue.close()
+	}
+	if config.SweepInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.sweepExpired(config.SweepInterval, config.ArchiveExpired, stop)
+		if config.TrashRetention > 0 {
+			go s.purgeTrash(config.SweepInterval, config.TrashRetention, stop)
+		}
+		if config.DeletionGrace > 0 {
+			go s.deleteDueUsers(config.SweepInterval, stop)
+		}
+	}
+	httpServer := &http.Server{
+		Addr:    "0.0.0.0:8000",
+		Handler: s.handler,
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	idle := make(chan struct{})
+	go func() {
+		<-shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+		close(idle)
+	}()
+
+	err = httpServer.ListenAndServe()
+	if err != http.ErrServerClosed {
+		fmt.Println(err)
+		return
+	}
+	<-idle
+}
+
+// NewHandler builds the router for the shortener backed by store, so the
+// whole site can be served from Run or from an httptest server. Clicks are
+// recorded as they happen and nothing is swept in the background.
+func NewHandler(config Config, store database.Store) (http.Handler, error) {
+	s, err := newServer(config, store)
+	if err != nil {
+		return nil, err
 	}
+	return s.handler, nil
 }
 
-func create() *http.Server {
+func newServer(config Config, store database.Store) (*server, error) {
+	codes, err := newShortCodeStrategy(config.ShortCodes, store)
+	if err != nil {
+		return nil, err
+	}
+	if config.RedirectStatus == 0 {
+		config.RedirectStatus = DefaultRedirectStatus
+	}
+	if !validRedirectStatus(config.RedirectStatus) {
+		return nil, fmt.Errorf("redirect status must be one of 301, 302, 307 or 308")
+	}
+	s := &server{
+		config:           config,
+		store:            store,
+		jwtSecret:        []byte(config.Secret),
+		linkAccessSecret: deriveKey([]byte(config.Secret), "link access"),
+		shortCodes:       codes,
+		destinations:     newDestinationPolicy(config.Destinations),
+	}
+	if config.GeoIPDatabase != "" {
+		s.geoDB, err = geoip2.Open(config.GeoIPDatabase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	handler := mux.NewRouter()
-	handler.HandleFunc(routeMain, homePageRouteHandler)
-	handler.HandleFunc(routeLogin, mustBeLoggedOut(loginRouteHandler))
+	handler.HandleFunc(routeMain, s.homePageRouteHandler)
+	handler.HandleFunc(routeLogin, s.mustBeLoggedOut(s.loginRouteHandler))
 	handler.HandleFunc(routeLogout, logoutRouteHandler)
-	handler.HandleFunc(routeCreateUser, mustBeLoggedOut(createUserHandler))
-	handler.HandleFunc(routeDeleteUser, mustBeLoggedIn(deleteUserHandler))
-	handler.HandleFunc(routeMyLinks, mustBeLoggedIn(myLinksHandler))
-	handler.HandleFunc(routeRedirect, redirectRouteHandler)
-	handler.HandleFunc(routeDeleteURL, mustBeLoggedIn(deleteURLRouteHandler))
+	handler.HandleFunc(routeCreateUser, s.mustBeLoggedOut(s.createUserHandler))
+	handler.HandleFunc(routeDeleteUser, s.mustBeLoggedIn(s.deleteUserHandler))
+	handler.HandleFunc(routeMyLinks, s.mustBeLoggedIn(s.myLinksHandler))
+	handler.HandleFunc(routeCampaigns, s.mustBeLoggedIn(s.handleCampaignsUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkDetail, s.mustBeLoggedIn(s.linkDetailHandler))
+	handler.HandleFunc(routeLinkEdit, s.mustBeLoggedIn(s.handleDestinationUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkUndo, s.mustBeLoggedIn(s.handleRollback)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkRules, s.mustBeLoggedIn(s.handleRulesUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkSplit, s.mustBeLoggedIn(s.handleVariantsUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkLock, s.mustBeLoggedIn(s.handlePasswordUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkWarn, s.mustBeLoggedIn(s.handleInterstitialUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkStatus, s.mustBeLoggedIn(s.handleRedirectUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkPass, s.mustBeLoggedIn(s.handlePassthroughUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routeLinkUTM, s.mustBeLoggedIn(s.handleUTMUpdate)).Methods(http.MethodPost)
+	handler.HandleFunc(routePreview, s.previewRouteHandler)
+	handler.HandleFunc(routePreviewAlt, s.previewRouteHandler)
+	handler.HandleFunc(routeRedirect, s.redirectRouteHandler)
+	handler.HandleFunc(routeRedirectTo, s.redirectRouteHandler)
+	handler.HandleFunc(routeDeleteURL, s.mustBeLoggedIn(s.deleteURLRouteHandler))
+	handler.HandleFunc(routeRestoreURL, s.mustBeLoggedIn(s.handleRestoreURL)).Methods(http.MethodPost)
+	handler.HandleFunc(routePurgeURL, s.mustBeLoggedIn(s.handlePurgeURL)).Methods(http.MethodPost)
+	handler.HandleFunc(routeAPIURLs, s.handleAPICreateLink).Methods(http.MethodPost)
+	handler.HandleFunc(routeAPIURL, s.handleAPIEditLink).Methods(http.MethodPatch)
 
-	return &http.Server{
-		Addr:    "0.0.0.0:8000",
-		Handler: handler,
+	s.customCodes, err = newCodeValidator(config.CustomCodes, handler)
+	if err != nil {
+		return nil, err
 	}
+	s.handler = handler
+
+	return s, nil
 }
 
-func mustBeLoggedIn(f http.HandlerFunc) http.HandlerFunc {
+func (s *server) mustBeLoggedIn(f http.HandlerFunc) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		_, err := verifyUsernameCookie(res, req)
+		_, err := s.verifyUsernameCookie(res, req)
 		if err != nil {
 			http.SetCookie(res, &http.Cookie{
 				Name:    "error",
@@ -64,9 +204,9 @@ func mustBeLoggedIn(f http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func mustBeLoggedOut(f http.HandlerFunc) http.HandlerFunc {
+func (s *server) mustBeLoggedOut(f http.HandlerFunc) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		_, err := verifyUsernameCookie(res, req)
+		_, err := s.verifyUsernameCookie(res, req)
 		if err == nil {
 			http.SetCookie(res, &http.Cookie{
 				Name:    "error",
@@ -81,23 +221,23 @@ func mustBeLoggedOut(f http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func homePageRouteHandler(res http.ResponseWriter, req *http.Request) {
+func (s *server) homePageRouteHandler(res http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		showHomePage(res, req)
+		s.showHomePage(res, req)
 	case http.MethodPost:
-		handleURLUpload(res, req)
+		s.handleURLUpload(res, req)
 	default:
 		http.Redirect(res, req, routeMain, http.StatusSeeOther)
 	}
 }
 
-func loginRouteHandler(res http.ResponseWriter, req *http.Request) {
+func (s *server) loginRouteHandler(res http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		showLoginPage(res, req)
 	case http.MethodPost:
-		handleLogin(res, req)
+		s.handleLogin(res, req)
 	default:
 		http.Redirect(res, req, routeMain, http.StatusSeeOther)
 	}
@@ -112,39 +252,92 @@ func logoutRouteHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func createUserHandler(res http.ResponseWriter, req *http.Request) {
+func (s *server) createUserHandler(res http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		showCreateUserPage(res, req)
 	case http.MethodPost:
-		handleCreation(res, req)
+		s.handleCreation(res, req)
 	default:
 		http.Redirect(res, req, routeMain, http.StatusSeeOther)
 	}
 }
 
-func deleteUserHandler(res http.ResponseWriter, req *http.Request) {
+func (s *server) deleteUserHandler(res http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		handleDeleteUser(res, req)
+		s.showDeleteUserPage(res, req)
+	case http.MethodPost:
+		s.handleDeleteUser(res, req)
 	default:
 		http.Redirect(res, req, routeMain, http.StatusSeeOther)
 	}
 }
 
-func myLinksHandler(res http.ResponseWriter, req *http.Request) {
+func (s *server) myLinksHandler(res http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		showMyLinksPage(res, req)
+		s.showMyLinksPage(res, req)
 	default:
 		http.Redirect(res, req, routeMain, http.StatusSeeOther)
 	}
 }
 
-func redirectRouteHandler(res http.ResponseWriter, req *http.Request) {
+func (s *server) linkDetailHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		s.showLinkDetailsPage(res, req)
+	default:
+		http.Redirect(res, req, routeMain, http.StatusSeeOther)
+	}
+}
+
+func (s *server) redirectRouteHandler(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	shortened, _ := vars["key"]
-	url, err := database.GetUrl(shortened)
+	url, ok := s.findLink(res, req, shortened)
+	if !ok {
+		return
+	}
+	if url.Expired(time.Now()) {
+		showExpiredPage(res, req, url)
+		return
+	}
+	ok, justUnlocked := s.unlockLink(res, req, url)
+	if !ok {
+		return
+	}
+	if url.Interstitial && req.PostFormValue("confirm") == "" {
+		s.showPreviewPage(res, req, url, true, justUnlocked)
+		return
+	}
+	click := s.newClick(req, shortened)
+	destination := destinationFor(url, res, req, &click)
+	_, err := s.destinations.checkStatic(destination)
+	if err != nil {
+		destination = url.Long
+		click.Variant = ""
+	}
+	if !url.UTM.IsZero() {
+		destination = withUTM(destination, url.UTM)
+	}
+	if url.Passthrough {
+		destination = passThrough(destination, vars["rest"], req.URL.Query())
+	}
+	// HEAD requests come from link checkers and previews rather than
+	// visitors, so they are answered like a GET but not counted.
+	if req.Method != http.MethodHead {
+		s.recordClick(click, url)
+	}
+	status := s.redirectStatusFor(url, req)
+	setCacheControl(res, url, status)
+	http.Redirect(res, req, destination, status)
+}
+
+// findLink looks up a short link for a visitor, sending them back to the home
+// page with an error if it does not exist or points somewhere now blocked.
+func (s *server) findLink(res http.ResponseWriter, req *http.Request, shortened string) (database.Record, bool) {
+	url, err := s.store.GetUrl(shortened)
 	if err != nil {
 		http.SetCookie(res, &http.Cookie{
 			Name:    "error",
@@ -153,7 +346,18 @@ func redirectRouteHandler(res http.ResponseWriter, req *http.Request) {
 			Path:    routeMain,
 		})
 		http.Redirect(res, req, routeMain, http.StatusSeeOther)
-		return
+		return database.Record{}, false
+	}
+	_, err = s.destinations.checkStatic(url.Long)
+	if err != nil {
+		http.SetCookie(res, &http.Cookie{
+			Name:    "error",
+			Value:   "shortened url points to a blocked destination",
+			Expires: time.Now().Add(time.Minute),
+			Path:    routeMain,
+		})
+		http.Redirect(res, req, routeMain, http.StatusSeeOther)
+		return database.Record{}, false
 	}
-	http.Redirect(res, req, url.Long, http.StatusSeeOther)
+	return url, true
 }
@@ -0,0 +1,134 @@
+package webserver_test
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"urlShortener/pkg/database"
+	"urlShortener/pkg/webserver"
+)
+
+var testConfig = webserver.Config{
+	Secret:       "test secret",
+	ShortCodes:   webserver.ShortCodeConfig{Length: 8, MaxLength: 12},
+	CustomCodes:  webserver.CustomCodeConfig{MinLength: 3, MaxLength: 32},
+	Destinations: webserver.DestinationConfig{AllowPrivate: true},
+}
+
+func newTestServer(t *testing.T, store database.Store) *httptest.Server {
+	t.Helper()
+	return newTestServerWith(t, testConfig, store)
+}
+
+func newTestServerWith(t *testing.T, config webserver.Config, store database.Store) *httptest.Server {
+	t.Helper()
+	handler, err := webserver.NewHandler(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newClient returns a client that keeps cookies but does not follow
+// redirects, so tests can check where each response points.
+func newClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func post(t *testing.T, client *http.Client, target string, form url.Values) *http.Response {
+	t.Helper()
+	res, err := client.PostForm(target, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func get(t *testing.T, client *http.Client, target string) *http.Response {
+	t.Helper()
+	res, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestShortenAndFollow(t *testing.T) {
+	store := database.NewMemoryStore()
+	server := newTestServer(t, store)
+	client := newClient(t)
+
+	post(t, client, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+	post(t, client, server.URL+"/", url.Values{"url": {"https://example.com/doc"}, "urlRequest": {"doc"}})
+
+	res := get(t, client, server.URL+"/u/doc")
+	if res.StatusCode != webserver.DefaultRedirectStatus {
+		t.Fatalf("got status %d, want %d", res.StatusCode, webserver.DefaultRedirectStatus)
+	}
+	if location := res.Header.Get("Location"); location != "https://example.com/doc" {
+		t.Fatalf("redirected to %q", location)
+	}
+	if !store.VerifyOwns("bob", "doc") {
+		t.Fatal("link was not made bob's")
+	}
+}
+
+func TestHandlersAreIndependent(t *testing.T) {
+	first := newTestServer(t, database.NewMemoryStore())
+	second := newTestServer(t, database.NewMemoryStore())
+	client := newClient(t)
+
+	post(t, client, first.URL+"/", url.Values{"url": {"https://example.com/first"}, "urlRequest": {"shared"}})
+	post(t, client, second.URL+"/", url.Values{"url": {"https://example.com/second"}, "urlRequest": {"shared"}})
+
+	for server, want := range map[*httptest.Server]string{
+		first:  "https://example.com/first",
+		second: "https://example.com/second",
+	} {
+		res := get(t, client, server.URL+"/u/shared")
+		if location := res.Header.Get("Location"); location != want {
+			t.Errorf("redirected to %q, want %q", location, want)
+		}
+	}
+}
+
+func TestDeleteRequiresPost(t *testing.T) {
+	store := database.NewMemoryStore()
+	server := newTestServer(t, store)
+	client := newClient(t)
+
+	post(t, client, server.URL+"/createUser", url.Values{"username": {"bob"}, "password": {"pw"}})
+	post(t, client, server.URL+"/", url.Values{"url": {"https://example.com/doc"}, "urlRequest": {"doc"}})
+
+	get(t, client, server.URL+"/d/doc")
+	_, err := store.GetUrl("doc")
+	if err != nil {
+		t.Fatal("a GET deleted the link")
+	}
+
+	post(t, client, server.URL+"/d/doc", nil)
+	_, err = store.GetUrl("doc")
+	if err == nil {
+		t.Fatal("link was not moved to the trash")
+	}
+	trash, err := store.GetTrashOf("bob")
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("got trash %v, %v", trash, err)
+	}
+}